@@ -34,6 +34,20 @@ func (fb *FrameBuilder) Dimensions(width, height int) *FrameBuilder {
 	return fb
 }
 
+// Offset sets the position in pixels of the frame data within the image,
+// for frames that only cover part of it.
+func (fb *FrameBuilder) Offset(x, y int) *FrameBuilder {
+	fb.cmd.SetKeyInt("x", x)
+	fb.cmd.SetKeyInt("y", y)
+	return fb
+}
+
+// Compress marks the frame data as ZLIB compressed.
+func (fb *FrameBuilder) Compress() *FrameBuilder {
+	fb.cmd.SetKey("o", string(CompressionZlib))
+	return fb
+}
+
 // FrameNumber sets the frame number to edit (replaces an existing frame instead of appending).
 func (fb *FrameBuilder) FrameNumber(frameNum uint32) *FrameBuilder {
 	fb.cmd.SetKeyUint32("r", frameNum)
@@ -78,6 +92,16 @@ func (fb *FrameBuilder) Build() *Command {
 	return fb.cmd
 }
 
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (fb *FrameBuilder) BuildChecked() (*Command, error) {
+	cmd := fb.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
 // AnimateBuilder builds an animate action command for controlling animation playback.
 type AnimateBuilder struct {
 	cmd *Command
@@ -127,6 +151,16 @@ func (ab *AnimateBuilder) Build() *Command {
 	return ab.cmd
 }
 
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (ab *AnimateBuilder) BuildChecked() (*Command, error) {
+	cmd := ab.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
 // ComposeBuilder builds a compose action command for composing animation frames.
 type ComposeBuilder struct {
 	cmd *Command
@@ -186,6 +220,16 @@ func (cb *ComposeBuilder) Build() *Command {
 	return cb.cmd
 }
 
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (cb *ComposeBuilder) BuildChecked() (*Command, error) {
+	cmd := cb.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
 // Helper functions for common animation operations
 
 // PlayAnimation plays an animation using LoopCount(2).
@@ -50,6 +50,24 @@ func TestFrameBuilder_Dimensions(t *testing.T) {
 	}
 }
 
+// TestFrameBuilder_Offset tests setting the frame offset
+func TestFrameBuilder_Offset(t *testing.T) {
+	fb := NewFrame(10)
+	fb.Offset(3, 4)
+	if fb.cmd.controlData["x"] != "3" || fb.cmd.controlData["y"] != "4" {
+		t.Errorf("Expected x=3,y=4, got x=%s,y=%s", fb.cmd.controlData["x"], fb.cmd.controlData["y"])
+	}
+}
+
+// TestFrameBuilder_Compress tests enabling compression
+func TestFrameBuilder_Compress(t *testing.T) {
+	fb := NewFrame(10)
+	fb.Compress()
+	if fb.cmd.controlData["o"] != "z" {
+		t.Errorf("Expected compression 'z', got %s", fb.cmd.controlData["o"])
+	}
+}
+
 // TestFrameBuilder_FrameNumber tests setting frame number for editing
 func TestFrameBuilder_FrameNumber(t *testing.T) {
 	fb := NewFrame(10)
@@ -0,0 +1,356 @@
+package kgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"time"
+)
+
+// ErrInvalidAPNG indicates malformed APNG data.
+var ErrInvalidAPNG = errors.New("invalid APNG")
+
+// pngSignature starts every PNG and APNG stream.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNGDispose says how a frame's area is disposed of before the next frame.
+type APNGDispose uint8
+
+const (
+	APNGDisposeNone       APNGDispose = 0 // leave the frame in place
+	APNGDisposeBackground APNGDispose = 1 // clear the area to transparent black
+	APNGDisposePrevious   APNGDispose = 2 // restore the area to its state before the frame
+)
+
+// APNGBlend says how a frame is combined with the canvas.
+type APNGBlend uint8
+
+const (
+	APNGBlendSource APNGBlend = 0 // replace the area, including alpha
+	APNGBlendOver   APNGBlend = 1 // alpha blend over the area
+)
+
+// APNGFrame is one frame of an animated PNG.
+type APNGFrame struct {
+	Image   image.Image // frame pixels; its bounds start at the origin
+	X, Y    int         // position of the frame on the canvas
+	Delay   time.Duration
+	Dispose APNGDispose
+	Blend   APNGBlend
+}
+
+// Bounds returns the area of the canvas the frame covers.
+func (f *APNGFrame) Bounds() image.Rectangle {
+	b := f.Image.Bounds()
+	return image.Rect(f.X, f.Y, f.X+b.Dx(), f.Y+b.Dy())
+}
+
+// APNG is a decoded animated PNG.
+type APNG struct {
+	Width, Height int
+	// LoopCount is the number of times to play the animation; 0 plays it forever.
+	LoopCount int
+	Frames    []APNGFrame
+}
+
+// apngChunk is a raw PNG chunk.
+type apngChunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFrameData collects the control and compressed data of one frame.
+type apngFrameData struct {
+	fctl []byte
+	data []byte
+}
+
+// DecodeAPNG reads an animated PNG from r. Each frame is decoded with
+// image/png from its fdAT (or IDAT) chunks. A default image that is not part
+// of the animation is skipped. A PNG without an acTL chunk decodes as a
+// single frame animation.
+func DecodeAPNG(r io.Reader) (*APNG, error) {
+	br := bufio.NewReader(r)
+
+	var sig [len(pngSignature)]byte
+	if _, err := io.ReadFull(br, sig[:]); err != nil {
+		return nil, err
+	}
+	if string(sig[:]) != pngSignature {
+		return nil, fmt.Errorf("%w: not a PNG file", ErrInvalidAPNG)
+	}
+
+	var (
+		ihdr        []byte
+		header      []apngChunk // chunks before the image data, such as PLTE and tRNS
+		actl        []byte
+		frames      []*apngFrameData
+		current     *apngFrameData
+		defaultData []byte // image data of a default image outside the animation
+		seenIDAT    bool
+	)
+	for {
+		c, err := readAPNGChunk(br)
+		if err != nil {
+			return nil, err
+		}
+
+		switch c.typ {
+		case "IHDR":
+			if len(c.data) != 13 {
+				return nil, fmt.Errorf("%w: bad IHDR length %d", ErrInvalidAPNG, len(c.data))
+			}
+			if err := checkAPNGSize(c.data); err != nil {
+				return nil, err
+			}
+			ihdr = c.data
+		case "acTL":
+			if len(c.data) != 8 {
+				return nil, fmt.Errorf("%w: bad acTL length %d", ErrInvalidAPNG, len(c.data))
+			}
+			actl = c.data
+		case "fcTL":
+			if len(c.data) != 26 {
+				return nil, fmt.Errorf("%w: bad fcTL length %d", ErrInvalidAPNG, len(c.data))
+			}
+			current = &apngFrameData{fctl: c.data}
+			frames = append(frames, current)
+		case "IDAT":
+			seenIDAT = true
+			if current != nil {
+				current.data = append(current.data, c.data...)
+			} else {
+				defaultData = append(defaultData, c.data...)
+			}
+		case "fdAT":
+			if current == nil || len(c.data) < 4 {
+				return nil, fmt.Errorf("%w: unexpected fdAT chunk", ErrInvalidAPNG)
+			}
+			current.data = append(current.data, c.data[4:]...)
+		case "IEND":
+			if ihdr == nil {
+				return nil, fmt.Errorf("%w: missing IHDR chunk", ErrInvalidAPNG)
+			}
+			return buildAPNG(ihdr, header, actl, frames, defaultData)
+		default:
+			if !seenIDAT {
+				header = append(header, c)
+			}
+		}
+	}
+}
+
+// readAPNGChunk reads one chunk and checks its CRC.
+func readAPNGChunk(r io.Reader) (apngChunk, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w: missing IEND chunk", ErrInvalidAPNG)
+		}
+		return apngChunk{}, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n > 1<<31-1 {
+		return apngChunk{}, fmt.Errorf("%w: chunk length %d", ErrInvalidAPNG, n)
+	}
+	typ := string(hdr[4:])
+
+	// Grow the buffer as data arrives rather than trusting the length
+	var data bytes.Buffer
+	var sum [4]byte
+	if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+		return apngChunk{}, apngTruncated(typ, err)
+	}
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return apngChunk{}, apngTruncated(typ, err)
+	}
+	c := apngChunk{typ: typ, data: data.Bytes()}
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(c.data)
+	if crc.Sum32() != binary.BigEndian.Uint32(sum[:]) {
+		return apngChunk{}, fmt.Errorf("%w: bad CRC in %s chunk", ErrInvalidAPNG, c.typ)
+	}
+	return c, nil
+}
+
+// checkAPNGSize rejects an IHDR size that is zero, above the PNG limit of
+// 2^31-1, or too large to hold as RGBA pixels.
+func checkAPNGSize(ihdr []byte) error {
+	w := uint64(binary.BigEndian.Uint32(ihdr[0:]))
+	h := uint64(binary.BigEndian.Uint32(ihdr[4:]))
+	if w == 0 || h == 0 || w > 1<<31-1 || h > 1<<31-1 || w*h > math.MaxInt/4 {
+		return fmt.Errorf("%w: bad image size %dx%d", ErrInvalidAPNG, w, h)
+	}
+	return nil
+}
+
+// apngTruncated reports a chunk cut short by the end of the input.
+func apngTruncated(typ string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: truncated %s chunk", ErrInvalidAPNG, typ)
+	}
+	return err
+}
+
+func buildAPNG(ihdr []byte, header []apngChunk, actl []byte, frames []*apngFrameData, defaultData []byte) (*APNG, error) {
+	a := &APNG{
+		Width:  int(binary.BigEndian.Uint32(ihdr[0:])),
+		Height: int(binary.BigEndian.Uint32(ihdr[4:])),
+	}
+
+	if actl == nil || len(frames) == 0 {
+		img, err := decodeAPNGFrame(ihdr, header, a.Width, a.Height, defaultData)
+		if err != nil {
+			return nil, err
+		}
+		a.Frames = []APNGFrame{{Image: img}}
+		return a, nil
+	}
+	a.LoopCount = int(binary.BigEndian.Uint32(actl[4:]))
+
+	for i, f := range frames {
+		width := int(binary.BigEndian.Uint32(f.fctl[4:]))
+		height := int(binary.BigEndian.Uint32(f.fctl[8:]))
+		x := int(binary.BigEndian.Uint32(f.fctl[12:]))
+		y := int(binary.BigEndian.Uint32(f.fctl[16:]))
+		if width == 0 || height == 0 || x+width > a.Width || y+height > a.Height {
+			return nil, fmt.Errorf("%w: frame %d outside the canvas", ErrInvalidAPNG, i+1)
+		}
+
+		img, err := decodeAPNGFrame(ihdr, header, width, height, f.data)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i+1, err)
+		}
+
+		frame := APNGFrame{
+			Image:   img,
+			X:       x,
+			Y:       y,
+			Delay:   apngDelay(binary.BigEndian.Uint16(f.fctl[20:]), binary.BigEndian.Uint16(f.fctl[22:])),
+			Dispose: APNGDispose(f.fctl[24]),
+			Blend:   APNGBlend(f.fctl[25]),
+		}
+		if frame.Dispose > APNGDisposePrevious || frame.Blend > APNGBlendOver {
+			return nil, fmt.Errorf("%w: frame %d has bad dispose or blend op", ErrInvalidAPNG, i+1)
+		}
+		a.Frames = append(a.Frames, frame)
+	}
+	return a, nil
+}
+
+// decodeAPNGFrame decodes compressed image data as a standalone PNG of the
+// given size, sharing the header chunks of the animation.
+func decodeAPNGFrame(ihdr []byte, header []apngChunk, width, height int, data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: missing image data", ErrInvalidAPNG)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	hdr := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(height))
+	writeAPNGChunk(&buf, "IHDR", hdr)
+	for _, c := range header {
+		writeAPNGChunk(&buf, c.typ, c.data)
+	}
+	writeAPNGChunk(&buf, "IDAT", data)
+	writeAPNGChunk(&buf, "IEND", nil)
+
+	return png.Decode(&buf)
+}
+
+func writeAPNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	buf.Write(n[:])
+	start := buf.Len()
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc32.ChecksumIEEE(buf.Bytes()[start:]))
+	buf.Write(n[:])
+}
+
+// apngDelay converts a delay fraction in seconds; a zero denominator means 100.
+func apngDelay(num, den uint16) time.Duration {
+	if den == 0 {
+		den = 100
+	}
+	return time.Duration(num) * time.Second / time.Duration(den)
+}
+
+// EncodeAPNG converts an animated PNG into the commands that upload and play
+// it as image imageID, like EncodeGIF. Frames map onto FrameBuilder: delays
+// become Gap, APNGBlendSource becomes CompositionReplace, and each frame uses
+// the one before it as its BackgroundFrame. The final AnimateBuilder command
+// loops forever or plays LoopCount times.
+//
+// Delays are rounded to milliseconds, with a minimum of 1 because the
+// protocol ignores a zero gap.
+func EncodeAPNG(a *APNG, imageID uint32) ([]*Command, error) {
+	if len(a.Frames) == 0 {
+		return nil, errors.New("apng has no frames")
+	}
+
+	e := newCanvasEncoder(imageID, image.Rect(0, 0, a.Width, a.Height))
+	for i := range a.Frames {
+		f := &a.Frames[i]
+		op := draw.Over
+		if f.Blend == APNGBlendSource {
+			op = draw.Src
+		}
+		d := disposeNone
+		switch f.Dispose {
+		case APNGDisposeBackground:
+			d = disposeBackground
+		case APNGDisposePrevious:
+			d = disposePrevious
+		}
+
+		bounds := f.Bounds()
+		src := &offsetImage{Image: f.Image, offset: bounds.Min.Sub(f.Image.Bounds().Min)}
+		if err := e.add(src, bounds, op, d, apngGap(f.Delay)); err != nil {
+			return nil, err
+		}
+	}
+
+	var loops uint32 = 1
+	if a.LoopCount > 0 {
+		loops = uint32(a.LoopCount) + 1
+	}
+	return e.finish(loops), nil
+}
+
+// apngGap returns the frame gap in milliseconds.
+func apngGap(d time.Duration) uint32 {
+	ms := (d + time.Millisecond/2) / time.Millisecond
+	if ms < 1 {
+		return 1
+	}
+	return uint32(ms)
+}
+
+// offsetImage moves an image by offset.
+type offsetImage struct {
+	image.Image
+	offset image.Point
+}
+
+func (o *offsetImage) Bounds() image.Rectangle {
+	return o.Image.Bounds().Add(o.offset)
+}
+
+func (o *offsetImage) At(x, y int) color.Color {
+	return o.Image.At(x-o.offset.X, y-o.offset.Y)
+}
@@ -0,0 +1,266 @@
+package kgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// apngTestFrame describes a frame for buildTestAPNG
+type apngTestFrame struct {
+	img           *image.Paletted
+	x, y          int
+	num, den      uint16
+	dispose, blnd byte
+}
+
+// buildTestAPNG assembles an APNG from paletted frames sharing gifPalette.
+// The first frame is stored in IDAT, the rest in fdAT chunks.
+func buildTestAPNG(t *testing.T, width, height int, plays uint32, frames []apngTestFrame) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	seq := uint32(0)
+
+	for i, f := range frames {
+		var enc bytes.Buffer
+		if err := png.Encode(&enc, f.img); err != nil {
+			t.Fatal(err)
+		}
+		enc.Next(len(pngSignature))
+		var idat []byte
+		for {
+			c, err := readAPNGChunk(&enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.typ == "IEND" {
+				break
+			}
+			switch {
+			case c.typ == "IDAT":
+				idat = append(idat, c.data...)
+			case i > 0:
+			case c.typ == "IHDR":
+				binary.BigEndian.PutUint32(c.data[0:], uint32(width))
+				binary.BigEndian.PutUint32(c.data[4:], uint32(height))
+				writeAPNGChunk(&out, "IHDR", c.data)
+				actl := make([]byte, 8)
+				binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+				binary.BigEndian.PutUint32(actl[4:], plays)
+				writeAPNGChunk(&out, "acTL", actl)
+			default:
+				writeAPNGChunk(&out, c.typ, c.data)
+			}
+		}
+
+		fctl := make([]byte, 26)
+		b := f.img.Bounds()
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(f.x))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(f.y))
+		binary.BigEndian.PutUint16(fctl[20:], f.num)
+		binary.BigEndian.PutUint16(fctl[22:], f.den)
+		fctl[24], fctl[25] = f.dispose, f.blnd
+		writeAPNGChunk(&out, "fcTL", fctl)
+		seq++
+
+		if i == 0 {
+			writeAPNGChunk(&out, "IDAT", idat)
+		} else {
+			fdat := binary.BigEndian.AppendUint32(nil, seq)
+			writeAPNGChunk(&out, "fdAT", append(fdat, idat...))
+			seq++
+		}
+	}
+	writeAPNGChunk(&out, "IEND", nil)
+	return out.Bytes()
+}
+
+func testAPNG(t *testing.T) []byte {
+	return buildTestAPNG(t, 4, 4, 2, []apngTestFrame{
+		{img: gifFrame(image.Rect(0, 0, 4, 4), gifRed), num: 1, den: 10,
+			dispose: byte(APNGDisposeNone), blnd: byte(APNGBlendSource)},
+		{img: gifFrame(image.Rect(0, 0, 2, 2), gifGreen), x: 1, y: 1,
+			dispose: byte(APNGDisposeBackground), blnd: byte(APNGBlendOver)},
+		{img: gifFrame(image.Rect(0, 0, 1, 1), gifBlue), x: 3, y: 3, num: 3, den: 1000,
+			dispose: byte(APNGDisposePrevious), blnd: byte(APNGBlendSource)},
+	})
+}
+
+// TestDecodeAPNG tests decoding frames with offsets, delays and ops
+func TestDecodeAPNG(t *testing.T) {
+	a, err := DecodeAPNG(bytes.NewReader(testAPNG(t)))
+	if err != nil {
+		t.Fatalf("DecodeAPNG error: %v", err)
+	}
+	if a.Width != 4 || a.Height != 4 || a.LoopCount != 2 {
+		t.Errorf("unexpected animation: %dx%d loops %d", a.Width, a.Height, a.LoopCount)
+	}
+	if len(a.Frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(a.Frames))
+	}
+
+	tests := []struct {
+		bounds  image.Rectangle
+		delay   time.Duration
+		dispose APNGDispose
+		blend   APNGBlend
+		color   any
+	}{
+		{image.Rect(0, 0, 4, 4), 100 * time.Millisecond, APNGDisposeNone, APNGBlendSource, gifRed},
+		{image.Rect(1, 1, 3, 3), 0, APNGDisposeBackground, APNGBlendOver, gifGreen},
+		{image.Rect(3, 3, 4, 4), 3 * time.Millisecond, APNGDisposePrevious, APNGBlendSource, gifBlue},
+	}
+	for i, tt := range tests {
+		f := a.Frames[i]
+		if f.Bounds() != tt.bounds || f.Delay != tt.delay || f.Dispose != tt.dispose || f.Blend != tt.blend {
+			t.Errorf("frame %d = %v %v %d %d, want %v %v %d %d",
+				i, f.Bounds(), f.Delay, f.Dispose, f.Blend, tt.bounds, tt.delay, tt.dispose, tt.blend)
+		}
+		if got := f.Image.At(0, 0); got != tt.color {
+			t.Errorf("frame %d color = %v, want %v", i, got, tt.color)
+		}
+	}
+}
+
+// TestDecodeAPNG_PlainPNG tests that a PNG without acTL is a single frame
+func TestDecodeAPNG_PlainPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gifFrame(image.Rect(0, 0, 3, 2), gifGreen)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := DecodeAPNG(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAPNG error: %v", err)
+	}
+	if len(a.Frames) != 1 || a.Frames[0].Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Errorf("unexpected frames: %+v", a.Frames)
+	}
+}
+
+// TestDecodeAPNG_Invalid tests rejection of malformed data
+func TestDecodeAPNG_Invalid(t *testing.T) {
+	data := testAPNG(t)
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(pngSignature)+10] ^= 0xff // inside IHDR
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not png", []byte("GIF89a..")},
+		{"bad crc", corrupt},
+		{"truncated", data[:len(data)-12]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeAPNG(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidAPNG) {
+				t.Errorf("expected ErrInvalidAPNG, got %v", err)
+			}
+		})
+	}
+}
+
+// TestDecodeAPNG_HugeChunkLength tests that a chunk length beyond the data
+// does not allocate the claimed size
+func TestDecodeAPNG_HugeChunkLength(t *testing.T) {
+	data := []byte(pngSignature + "\x7f\xff\xff\xffIDAT" + "short")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := DecodeAPNG(bytes.NewReader(data))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrInvalidAPNG) {
+		t.Errorf("expected ErrInvalidAPNG, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("allocated %d bytes for a truncated chunk", alloc)
+	}
+}
+
+// TestDecodeAPNG_BadSize tests that zero or oversized IHDR dimensions are rejected
+func TestDecodeAPNG_BadSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint32
+	}{
+		{"zero width", 0, 10},
+		{"zero height", 10, 0},
+		{"above PNG limit", 1 << 31, 1},
+		{"pixels overflow", 1<<31 - 1, 1<<31 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ihdr := make([]byte, 13)
+			binary.BigEndian.PutUint32(ihdr[0:], tt.width)
+			binary.BigEndian.PutUint32(ihdr[4:], tt.height)
+			ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA
+
+			var buf bytes.Buffer
+			buf.WriteString(pngSignature)
+			writeAPNGChunk(&buf, "IHDR", ihdr)
+			writeAPNGChunk(&buf, "IEND", nil)
+
+			if _, err := DecodeAPNG(&buf); !errors.Is(err, ErrInvalidAPNG) {
+				t.Errorf("expected ErrInvalidAPNG, got %v", err)
+			}
+		})
+	}
+}
+
+// TestEncodeAPNG tests mapping APNG frames onto frame and animate commands
+func TestEncodeAPNG(t *testing.T) {
+	a, err := DecodeAPNG(bytes.NewReader(testAPNG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := EncodeAPNG(a, 7)
+	if err != nil {
+		t.Fatalf("EncodeAPNG error: %v", err)
+	}
+	if len(cmds) != 5 {
+		t.Fatalf("expected 5 commands, got %d", len(cmds))
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Validate(); err != nil {
+			t.Errorf("command %d invalid: %v", i, err)
+		}
+	}
+
+	checkKeys(t, "root", cmds[0], map[string]string{"a": "t", "i": "7", "s": "4", "v": "4"})
+	checkKeys(t, "root gap", cmds[1], map[string]string{"r": "1", "z": "100"})
+	checkKeys(t, "frame 2", cmds[2], map[string]string{"c": "1", "z": "1", "x": "1", "y": "1", "s": "2", "v": "2", "X": ""})
+	checkKeys(t, "frame 3", cmds[3], map[string]string{"c": "2", "z": "3", "x": "1", "y": "1", "s": "3", "v": "3", "X": "1"})
+	checkKeys(t, "animate", cmds[4], map[string]string{"a": "a", "s": "3", "v": "3"})
+
+	a.LoopCount = 0
+	cmds, _ = EncodeAPNG(a, 7)
+	checkKeys(t, "forever", cmds[4], map[string]string{"v": "1"})
+}
+
+// TestEncodeAPNG_BlendSource tests that a source blended frame replaces its area
+func TestEncodeAPNG_BlendSource(t *testing.T) {
+	a := &APNG{Width: 2, Height: 2, Frames: []APNGFrame{
+		{Image: gifFrame(image.Rect(0, 0, 2, 2), gifRed)},
+		{Image: gifFrame(image.Rect(0, 0, 1, 1), gifTransparent), X: 1, Blend: APNGBlendSource},
+	}}
+	cmds, err := EncodeAPNG(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, "frame 2", cmds[2], map[string]string{"x": "1", "y": "0", "X": "1"})
+
+	if _, err := EncodeAPNG(&APNG{}, 1); err == nil {
+		t.Error("expected error for APNG without frames")
+	}
+}
@@ -0,0 +1,218 @@
+package kgp
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"image"
+	"sync"
+)
+
+// ImageCache uploads images to the terminal once and displays repeated
+// images with a new placement only. Images are keyed by a hash of their
+// pixel data. The cache tracks the decoded size of the images it uploaded
+// and deletes the least recently used ones when a memory budget is
+// exceeded. It is safe for concurrent use; calls are serialized.
+type ImageCache struct {
+	session *Session
+	ids     *IDAllocator
+	budget  int64
+
+	mu      sync.Mutex
+	usage   int64
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+}
+
+type cacheKey [sha256.Size]byte
+
+type cacheEntry struct {
+	key  cacheKey
+	id   uint32
+	size int64
+}
+
+// NewImageCache creates a cache sending commands through s. Image IDs are
+// taken from ids, or from a new IDAllocator if ids is nil. budget is the
+// maximum total decoded size in bytes of the cached images; zero means no
+// limit.
+func NewImageCache(s *Session, ids *IDAllocator, budget int64) *ImageCache {
+	if ids == nil {
+		ids = NewIDAllocator()
+	}
+	return &ImageCache{
+		session: s,
+		ids:     ids,
+		budget:  budget,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Show displays img with the display options of place, which may be nil to
+// display it at the cursor. The image ID of place is replaced. An image not
+// in the cache is transmitted first; if the terminal reports that a cached
+// image no longer exists (ENOENT), because it evicted the image itself, the
+// image is transmitted again transparently. It returns the image ID.
+//
+// place must not suppress error responses, or evicted images go unnoticed.
+func (c *ImageCache) Show(ctx context.Context, img image.Image, place *PutBuilder) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pixels := ImageToRGBA(img)
+	bounds := img.Bounds()
+	key := imageKey(bounds.Dx(), bounds.Dy(), pixels)
+
+	elem, cached := c.entries[key]
+	if !cached {
+		var err error
+		if elem, err = c.upload(ctx, key, bounds, pixels); err != nil {
+			return 0, err
+		}
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+
+	err := c.put(ctx, entry.id, place)
+	if cached && errors.Is(err, ErrNotFound) {
+		// The terminal dropped the image; upload it again under the same ID
+		if err = c.transmit(ctx, entry.id, bounds, pixels); err != nil {
+			c.remove(elem, false)
+			return 0, err
+		}
+		err = c.put(ctx, entry.id, place)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.evict(elem); err != nil {
+		return entry.id, err
+	}
+	return entry.id, nil
+}
+
+func imageKey(width, height int, pixels []byte) cacheKey {
+	h := sha256.New()
+	var dims [16]byte
+	binary.BigEndian.PutUint64(dims[:8], uint64(width))
+	binary.BigEndian.PutUint64(dims[8:], uint64(height))
+	h.Write(dims[:])
+	h.Write(pixels)
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// upload allocates an ID for a new image, evicting images if no ID is
+// free, and transmits it.
+func (c *ImageCache) upload(ctx context.Context, key cacheKey, bounds image.Rectangle, pixels []byte) (*list.Element, error) {
+	id, err := c.ids.Allocate()
+	for errors.Is(err, ErrIDsExhausted) && c.lru.Len() > 0 {
+		if err := c.remove(c.lru.Back(), true); err != nil {
+			return nil, err
+		}
+		id, err = c.ids.Allocate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.transmit(ctx, id, bounds, pixels); err != nil {
+		c.ids.Release(id)
+		return nil, err
+	}
+
+	entry := &cacheEntry{key: key, id: id, size: int64(len(pixels))}
+	elem := c.lru.PushFront(entry)
+	c.entries[key] = elem
+	c.usage += entry.size
+	return elem, nil
+}
+
+func (c *ImageCache) transmit(ctx context.Context, id uint32, bounds image.Rectangle, pixels []byte) error {
+	data, err := CompressZlib(pixels)
+	if err != nil {
+		return err
+	}
+	cmd := NewTransmit().
+		ImageID(id).
+		Format(FormatRGBA).
+		Dimensions(bounds.Dx(), bounds.Dy()).
+		Compress().
+		TransmitDirect(data).
+		Build()
+	_, err = c.session.Send(ctx, cmd)
+	return err
+}
+
+func (c *ImageCache) put(ctx context.Context, id uint32, place *PutBuilder) error {
+	var cmd *Command
+	if place != nil {
+		cmd = place.Build().clone()
+		cmd.SetKeyUint32("i", id)
+	} else {
+		cmd = NewPut(id).Build()
+	}
+	_, err := c.session.Send(ctx, cmd)
+	return err
+}
+
+// evict deletes least recently used images until the usage is within the
+// budget, keeping at least keep.
+func (c *ImageCache) evict(keep *list.Element) error {
+	for c.budget > 0 && c.usage > c.budget {
+		elem := c.lru.Back()
+		if elem == keep {
+			return nil
+		}
+		if err := c.remove(elem, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove forgets an image, deleting it from the terminal if del is set.
+func (c *ImageCache) remove(elem *list.Element, del bool) error {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.usage -= entry.size
+	c.ids.Release(entry.id)
+	if del {
+		_, err := c.session.Send(context.Background(), DeleteImageFree(entry.id))
+		return err
+	}
+	return nil
+}
+
+// Usage returns the total decoded size in bytes of the cached images.
+func (c *ImageCache) Usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// Len returns the number of cached images.
+func (c *ImageCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Clear deletes all cached images from the terminal and empties the cache.
+func (c *ImageCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for c.lru.Len() > 0 {
+		if err := c.remove(c.lru.Back(), true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,176 @@
+package kgp
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+)
+
+// cacheTerminal is a fake terminal storing transmitted images.
+type cacheTerminal struct {
+	mu       sync.Mutex
+	images   map[string]bool
+	commands []string
+}
+
+func newCacheTerminal(t *testing.T) (*cacheTerminal, *Session) {
+	ct := &cacheTerminal{images: make(map[string]bool)}
+	s := fakeTerminal(t, func(cmd *Command) string {
+		ct.mu.Lock()
+		defer ct.mu.Unlock()
+		id, _ := cmd.Key("i")
+		ct.commands = append(ct.commands, string(cmd.Action()))
+		switch cmd.Action() {
+		case ActionTransmit:
+			ct.images[id] = true
+		case ActionPut:
+			if !ct.images[id] {
+				return fmt.Sprintf("\x1b_Gi=%s;ENOENT:image not found\x1b\\", id)
+			}
+		case ActionDelete:
+			delete(ct.images, id)
+			return ""
+		}
+		return echoReply(cmd)
+	})
+	return ct, s
+}
+
+func (ct *cacheTerminal) log() []string {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	log := ct.commands
+	ct.commands = nil
+	return log
+}
+
+func (ct *cacheTerminal) has(id uint32) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.images[fmt.Sprint(id)]
+}
+
+func solidImage(width, height int, r, g, b, a uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	copy(img.Pix, SolidColorImage(width, height, r, g, b, a))
+	return img
+}
+
+// TestImageCache_Reuse tests that repeated images are only transmitted once
+func TestImageCache_Reuse(t *testing.T) {
+	ct, s := newCacheTerminal(t)
+	c := NewImageCache(s, nil, 0)
+	ctx := context.Background()
+
+	icon := solidImage(4, 4, 255, 0, 0, 255)
+	id1, err := c.Show(ctx, icon, nil)
+	if err != nil {
+		t.Fatalf("Show error: %v", err)
+	}
+	if got := ct.log(); fmt.Sprint(got) != "[t p]" {
+		t.Errorf("first Show sent %v", got)
+	}
+
+	id2, err := c.Show(ctx, solidImage(4, 4, 255, 0, 0, 255), NewPut(0).DisplaySize(2, 1))
+	if err != nil {
+		t.Fatalf("Show error: %v", err)
+	}
+	if id2 != id1 {
+		t.Errorf("same image got IDs %d and %d", id1, id2)
+	}
+	if got := ct.log(); fmt.Sprint(got) != "[p]" {
+		t.Errorf("second Show sent %v", got)
+	}
+
+	other := solidImage(4, 4, 0, 255, 0, 255)
+	if id3, _ := c.Show(ctx, other, nil); id3 == id1 {
+		t.Error("different images share an ID")
+	}
+	if c.Len() != 2 || c.Usage() != 2*4*4*4 {
+		t.Errorf("Len() = %d, Usage() = %d", c.Len(), c.Usage())
+	}
+}
+
+// TestImageCache_Eviction tests least recently used eviction within the budget
+func TestImageCache_Eviction(t *testing.T) {
+	ct, s := newCacheTerminal(t)
+	c := NewImageCache(s, nil, 2*4*4*4)
+	ctx := context.Background()
+
+	img := func(r uint8) *image.RGBA { return solidImage(4, 4, r, 0, 0, 255) }
+
+	a, _ := c.Show(ctx, img(1), nil)
+	b, _ := c.Show(ctx, img(2), nil)
+	c.Show(ctx, img(1), nil) // a is now most recently used
+	ct.log()
+
+	if _, err := c.Show(ctx, img(3), nil); err != nil {
+		t.Fatalf("Show error: %v", err)
+	}
+	// Deletes are not acknowledged; a round trip ensures the delete was processed
+	s.Send(ctx, QuerySupport())
+	if got := ct.log(); fmt.Sprint(got) != "[t p d q]" {
+		t.Errorf("Show sent %v", got)
+	}
+	if !ct.has(a) || ct.has(b) {
+		t.Errorf("expected %d to be evicted and %d kept", b, a)
+	}
+	if c.Len() != 2 || c.Usage() != 2*4*4*4 {
+		t.Errorf("Len() = %d, Usage() = %d", c.Len(), c.Usage())
+	}
+}
+
+// TestImageCache_ReuploadOnENOENT tests transparent re-upload of images the terminal dropped
+func TestImageCache_ReuploadOnENOENT(t *testing.T) {
+	ct, s := newCacheTerminal(t)
+	c := NewImageCache(s, nil, 0)
+	ctx := context.Background()
+
+	icon := solidImage(2, 2, 9, 9, 9, 255)
+	id, err := c.Show(ctx, icon, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The terminal evicts the image on its own
+	ct.mu.Lock()
+	delete(ct.images, fmt.Sprint(id))
+	ct.mu.Unlock()
+	ct.log()
+
+	again, err := c.Show(ctx, icon, nil)
+	if err != nil {
+		t.Fatalf("Show error: %v", err)
+	}
+	if again != id {
+		t.Errorf("re-upload changed ID from %d to %d", id, again)
+	}
+	if got := ct.log(); fmt.Sprint(got) != "[p t p]" {
+		t.Errorf("Show sent %v", got)
+	}
+	if !ct.has(id) {
+		t.Error("image not re-uploaded")
+	}
+}
+
+// TestImageCache_Clear tests deleting all cached images
+func TestImageCache_Clear(t *testing.T) {
+	ct, s := newCacheTerminal(t)
+	c := NewImageCache(s, nil, 0)
+	ctx := context.Background()
+
+	a, _ := c.Show(ctx, solidImage(1, 1, 1, 1, 1, 255), nil)
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear error: %v", err)
+	}
+	if c.Len() != 0 || c.Usage() != 0 {
+		t.Errorf("Len() = %d, Usage() = %d", c.Len(), c.Usage())
+	}
+	// Deletes are not acknowledged; a round trip ensures the delete was processed
+	s.Send(ctx, QuerySupport())
+	if ct.has(a) {
+		t.Error("image not deleted")
+	}
+}
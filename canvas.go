@@ -0,0 +1,119 @@
+package kgp
+
+import (
+	"image"
+	"image/draw"
+)
+
+// disposal says what happens to a frame's area before the next frame is drawn.
+type disposal uint8
+
+const (
+	disposeNone       disposal = iota // leave the frame in place
+	disposeBackground                 // clear the area to transparent black
+	disposePrevious                   // restore the area to its state before the frame
+)
+
+// canvasEncoder converts frames drawn onto a canvas, as described by GIF and
+// APNG, into the commands building the same animation in the terminal. It
+// keeps a copy of the canvas so frames following a disposal can carry the
+// exact pixels of the area that changed.
+type canvasEncoder struct {
+	imageID uint32
+	screen  image.Rectangle
+	canvas  *image.RGBA
+	dirty   image.Rectangle // area changed by the previous frame's disposal
+	cmds    []*Command
+}
+
+func newCanvasEncoder(imageID uint32, screen image.Rectangle) *canvasEncoder {
+	return &canvasEncoder{
+		imageID: imageID,
+		screen:  screen,
+		canvas:  image.NewRGBA(screen),
+	}
+}
+
+// add draws src over bounds of the canvas with op and appends the command for
+// the resulting frame. The first frame is transmitted as the root frame over
+// the whole canvas; later frames are based on the frame before them. A frame
+// following one with no disposal carries only its own rectangle, blended for
+// draw.Over; otherwise it carries the final pixels of the changed area and
+// replaces them.
+func (e *canvasEncoder) add(src image.Image, bounds image.Rectangle, op draw.Op, d disposal, gap uint32) error {
+	bounds = bounds.Intersect(e.screen)
+
+	var previous *image.RGBA
+	if d == disposePrevious {
+		previous = cloneRGBA(e.canvas)
+	}
+	draw.Draw(e.canvas, bounds, src, bounds.Min, op)
+
+	if len(e.cmds) == 0 {
+		data, err := CompressZlib(e.canvas.Pix)
+		if err != nil {
+			return err
+		}
+		e.cmds = append(e.cmds,
+			NewTransmit().
+				ImageID(e.imageID).
+				Format(FormatRGBA).
+				Dimensions(e.screen.Dx(), e.screen.Dy()).
+				Compress().
+				TransmitDirect(data).
+				Build(),
+			NewFrame(e.imageID).FrameNumber(1).Gap(gap).Build())
+	} else {
+		// The root frame accounts for two commands
+		fb := NewFrame(e.imageID).BackgroundFrame(uint32(len(e.cmds) - 1)).Gap(gap)
+		region := bounds
+		var pixels *image.RGBA
+		if e.dirty.Empty() {
+			pixels = image.NewRGBA(region)
+			draw.Draw(pixels, region, src, region.Min, draw.Src)
+			if op == draw.Src {
+				fb.Composition(CompositionReplace)
+			}
+		} else {
+			region = region.Union(e.dirty)
+			pixels = cloneRGBA(e.canvas.SubImage(region).(*image.RGBA))
+			fb.Composition(CompositionReplace)
+		}
+		if !region.Empty() {
+			data, err := CompressZlib(pixels.Pix)
+			if err != nil {
+				return err
+			}
+			fb.Format(FormatRGBA).
+				Dimensions(region.Dx(), region.Dy()).
+				Offset(region.Min.X-e.screen.Min.X, region.Min.Y-e.screen.Min.Y).
+				Compress().
+				FrameData(data)
+		}
+		e.cmds = append(e.cmds, fb.Build())
+	}
+
+	switch d {
+	case disposeBackground:
+		draw.Draw(e.canvas, bounds, image.Transparent, image.Point{}, draw.Src)
+		e.dirty = bounds
+	case disposePrevious:
+		e.canvas = previous
+		e.dirty = bounds
+	default:
+		e.dirty = image.Rectangle{}
+	}
+	return nil
+}
+
+// finish appends the command starting playback and returns all commands.
+func (e *canvasEncoder) finish(loopCount uint32) []*Command {
+	return append(e.cmds, NewAnimate(e.imageID).State(AnimationLoop).LoopCount(loopCount).Build())
+}
+
+// cloneRGBA returns a copy of img with tightly packed rows.
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Bounds())
+	draw.Draw(c, c.Bounds(), img, img.Bounds().Min, draw.Src)
+	return c
+}
@@ -0,0 +1,37 @@
+package kgp
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+)
+
+// TestCanvasEncoder tests background frame numbering and composition modes
+func TestCanvasEncoder(t *testing.T) {
+	e := newCanvasEncoder(3, image.Rect(0, 0, 2, 2))
+	red := gifFrame(image.Rect(0, 0, 2, 2), gifRed)
+	steps := []struct {
+		bounds image.Rectangle
+		op     draw.Op
+		d      disposal
+	}{
+		{image.Rect(0, 0, 2, 2), draw.Over, disposeNone},
+		{image.Rect(0, 0, 1, 1), draw.Over, disposeNone},
+		{image.Rect(1, 1, 2, 2), draw.Src, disposeNone},
+		{image.Rect(0, 0, 3, 3), draw.Over, disposeNone},
+	}
+	for _, s := range steps {
+		if err := e.add(red, s.bounds, s.op, s.d, 40); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmds := e.finish(1)
+	if len(cmds) != 6 {
+		t.Fatalf("expected 6 commands, got %d", len(cmds))
+	}
+	checkKeys(t, "blend", cmds[2], map[string]string{"c": "1", "X": "", "s": "1", "v": "1"})
+	checkKeys(t, "replace", cmds[3], map[string]string{"c": "2", "X": "1", "x": "1", "y": "1"})
+	// Bounds are clipped to the canvas
+	checkKeys(t, "clipped", cmds[4], map[string]string{"c": "3", "s": "2", "v": "2"})
+	checkKeys(t, "animate", cmds[5], map[string]string{"a": "a", "v": "1"})
+}
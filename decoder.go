@@ -0,0 +1,228 @@
+package kgp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Decoder reads graphics commands from a byte stream. The stream may contain
+// arbitrary text and other escape sequences between graphics commands, and
+// sequences may be split across reads. Chunked transmissions are joined into
+// a single logical command.
+type Decoder struct {
+	sr         *sequenceReader
+	decompress bool
+	pending    map[string]*Command
+	lastKey    string
+}
+
+// NewDecoder creates a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		sr:      newSequenceReader(r),
+		pending: make(map[string]*Command),
+	}
+}
+
+// Decompress controls whether zlib-compressed (o=z) direct payloads are
+// decompressed. Decompressed commands no longer carry the o key.
+func (d *Decoder) Decompress(enable bool) {
+	d.decompress = enable
+}
+
+// Decode returns the next complete graphics command. Chunks of a chunked
+// transmission are joined into one command whose payload is the complete
+// data and which no longer carries the m key. Continuation chunks are matched
+// to their transmission by image ID when they carry one.
+//
+// Decode returns io.EOF at the end of the stream, or io.ErrUnexpectedEOF if
+// the stream ends inside a sequence or a chunked transmission. A malformed
+// sequence yields an error, after which decoding may continue.
+func (d *Decoder) Decode() (*Command, error) {
+	for {
+		body, err := d.sr.next()
+		if err != nil {
+			if err == io.EOF && len(d.pending) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		chunk, err := parseCommandBody(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		more := chunk.controlData["m"] == "1"
+		delete(chunk.controlData, "m")
+
+		key, cmd := d.pendingFor(chunk)
+		if cmd == nil {
+			if more {
+				d.pending[key] = chunk
+				d.lastKey = key
+				continue
+			}
+			return d.finish(chunk)
+		}
+
+		// Continuation chunk: only the payload contributes to the command
+		cmd.payload = append(cmd.payload, chunk.payload...)
+		if more {
+			continue
+		}
+		delete(d.pending, key)
+		return d.finish(cmd)
+	}
+}
+
+// pendingFor returns the chunked transmission a chunk continues, if any,
+// along with the key identifying it. Only chunks whose control data is
+// limited to the continuation keys, and a=f for frame data, continue a
+// transmission; anything else starts a new command.
+func (d *Decoder) pendingFor(chunk *Command) (string, *Command) {
+	key, hasID := chunk.controlData["i"]
+	if !isContinuation(chunk) {
+		return key, nil
+	}
+	if cmd, ok := d.pending[key]; ok {
+		return key, cmd
+	}
+	if !hasID && len(d.pending) > 0 {
+		// Chunks without an image ID continue the most recent transmission.
+		if cmd, ok := d.pending[d.lastKey]; ok {
+			return d.lastKey, cmd
+		}
+	}
+	return key, nil
+}
+
+// isContinuation reports whether the control data of a chunk, without the m
+// key, can belong to a continuation chunk.
+func isContinuation(chunk *Command) bool {
+	for k, v := range chunk.controlData {
+		switch {
+		case k == "q" || k == "i":
+		case k == "a" && v == string(ActionFrame):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Decoder) finish(cmd *Command) (*Command, error) {
+	if !d.decompress || cmd.controlData["o"] != string(CompressionZlib) {
+		return cmd, nil
+	}
+	if t, ok := cmd.controlData["t"]; ok && t != string(TransmitDirect) {
+		// Non-direct payloads are file paths or shared memory names
+		return cmd, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(cmd.payload))
+	if err != nil {
+		return nil, fmt.Errorf("decompress payload: %w", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress payload: %w", err)
+	}
+	cmd.payload = data
+	delete(cmd.controlData, "o")
+	return cmd, nil
+}
+
+// sequenceReader extracts graphics APC sequences from a byte stream,
+// skipping everything else.
+type sequenceReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newSequenceReader(r io.Reader) *sequenceReader {
+	return &sequenceReader{r: bufio.NewReader(r)}
+}
+
+// next skips to the next ESC_G introducer and returns the sequence body up
+// to, but excluding, the ESC\ terminator. The returned slice is only valid
+// until the next call.
+func (sr *sequenceReader) next() ([]byte, error) {
+	for {
+		b, err := sr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\x1b' {
+			continue
+		}
+
+		ok, err := sr.expect('_')
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		ok, err = sr.expect('G')
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Some other APC sequence; skip its contents
+			if _, err := sr.readUntilST(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return sr.readUntilST()
+	}
+}
+
+// expect consumes the next byte if it equals want. A mismatched byte is left
+// unread so it can start a new sequence.
+func (sr *sequenceReader) expect(want byte) (bool, error) {
+	b, err := sr.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	if b != want {
+		sr.r.UnreadByte()
+		return false, nil
+	}
+	return true, nil
+}
+
+// readUntilST reads up to and including the ESC\ string terminator and
+// returns the bytes before it.
+func (sr *sequenceReader) readUntilST() ([]byte, error) {
+	sr.buf = sr.buf[:0]
+	for {
+		part, err := sr.r.ReadSlice('\x1b')
+		sr.buf = append(sr.buf, part...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		ok, err := sr.expect('\\')
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return sr.buf[:len(sr.buf)-1], nil
+		}
+	}
+}
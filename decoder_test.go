@@ -0,0 +1,217 @@
+package kgp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestDecoderMixedStream tests decoding commands interleaved with text and other sequences
+func TestDecoderMixedStream(t *testing.T) {
+	put := NewPut(10).PlacementID(2).Build()
+	del := DeleteImage(10)
+
+	stream := "hello \x1b[31mred\x1b[0m" + put.Encode() +
+		"\x1b_Xother apc\x1b\\ text\x1b" + del.Encode() + "tail"
+
+	d := NewDecoder(iotest.OneByteReader(strings.NewReader(stream)))
+
+	got, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if got.Encode() != put.Encode() {
+		t.Errorf("first command = %q, want %q", got.Encode(), put.Encode())
+	}
+
+	got, err = d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if got.Encode() != del.Encode() {
+		t.Errorf("second command = %q, want %q", got.Encode(), del.Encode())
+	}
+
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// TestDecoderJoinsChunks tests reassembly of a chunked transmission
+func TestDecoderJoinsChunks(t *testing.T) {
+	data := SolidColorImage(512, 512, 10, 20, 30, 255)
+
+	var buf bytes.Buffer
+	_, err := NewTransmit().
+		ImageID(42).
+		Format(FormatRGBA).
+		Dimensions(512, 512).
+		TransmitReader(bytes.NewReader(data)).
+		WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+
+	d := NewDecoder(iotest.HalfReader(&buf))
+	cmd, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if _, ok := cmd.Key("m"); ok {
+		t.Error("joined command should not carry the m key")
+	}
+	if v, _ := cmd.Key("i"); v != "42" {
+		t.Errorf("image ID = %q, want 42", v)
+	}
+	if v, _ := cmd.Key("s"); v != "512" {
+		t.Errorf("width = %q, want 512", v)
+	}
+	if !bytes.Equal(cmd.Payload(), data) {
+		t.Errorf("payload length = %d, want %d", len(cmd.Payload()), len(data))
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// TestDecoderInterleavedChunks tests chunked transmissions interleaved by image ID
+func TestDecoderInterleavedChunks(t *testing.T) {
+	a := NewTransmit().ImageID(1).TransmitDirect(bytes.Repeat([]byte("a"), 30)).Build()
+	b := NewTransmit().ImageID(2).TransmitDirect(bytes.Repeat([]byte("b"), 30)).Build()
+	ca := a.EncodeChunked(16)
+	cb := b.EncodeChunked(16)
+
+	var stream strings.Builder
+	for i := range ca {
+		stream.WriteString(ca[i])
+		stream.WriteString(cb[i])
+	}
+
+	d := NewDecoder(strings.NewReader(stream.String()))
+	for _, want := range []*Command{a, b} {
+		got, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode error: %v", err)
+		}
+		if !bytes.Equal(got.Payload(), want.Payload()) {
+			t.Errorf("payload = %q, want %q", got.Payload(), want.Payload())
+		}
+	}
+}
+
+// TestDecoderCommandBetweenChunks tests that a complete command between the
+// chunks of a transmission is not taken for a continuation chunk
+func TestDecoderCommandBetweenChunks(t *testing.T) {
+	transmit := NewTransmit().Format(FormatPNG).TransmitDirect(bytes.Repeat([]byte("x"), 5000)).Build()
+	chunks := transmit.EncodeChunked(4096)
+	stream := chunks[0] + DeleteAll().Encode() + chunks[1]
+
+	d := NewDecoder(strings.NewReader(stream))
+	del, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if del.Action() != ActionDelete {
+		t.Fatalf("expected the delete command first, got action %q", del.Action())
+	}
+	got, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !bytes.Equal(got.Payload(), transmit.Payload()) {
+		t.Errorf("payload length = %d, want %d", len(got.Payload()), len(transmit.Payload()))
+	}
+}
+
+// TestDecoderFrameChunks tests joining chunked frame data carrying a=f
+func TestDecoderFrameChunks(t *testing.T) {
+	frame := NewFrame(5).Format(FormatRGBA).Dimensions(40, 40).FrameData(make([]byte, 6400)).Build()
+	stream := strings.Join(frame.EncodeChunked(4096), "")
+
+	cmd, err := NewDecoder(strings.NewReader(stream)).Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if cmd.Action() != ActionFrame || len(cmd.Payload()) != 6400 {
+		t.Errorf("got action %q with %d bytes", cmd.Action(), len(cmd.Payload()))
+	}
+}
+
+// TestDecoderLegacyChunks tests continuation chunks that carry only the m key
+func TestDecoderLegacyChunks(t *testing.T) {
+	stream := "\x1b_Ga=t,f=100,m=1;dGVz\x1b\\\x1b_Gm=1;dCBk\x1b\\\x1b_Gm=0;YXRh\x1b\\"
+
+	cmd, err := NewDecoder(strings.NewReader(stream)).Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if string(cmd.Payload()) != "test data" {
+		t.Errorf("payload = %q, want %q", cmd.Payload(), "test data")
+	}
+}
+
+// TestDecoderDecompress tests optional decompression of zlib payloads
+func TestDecoderDecompress(t *testing.T) {
+	data := SolidColorImage(16, 16, 1, 2, 3, 4)
+	compressed, err := CompressZlib(data)
+	if err != nil {
+		t.Fatalf("CompressZlib error: %v", err)
+	}
+	encoded := NewTransmit().Format(FormatRGBA).Dimensions(16, 16).Compress().TransmitDirect(compressed).Build().Encode()
+
+	d := NewDecoder(strings.NewReader(encoded))
+	d.Decompress(true)
+	cmd, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !bytes.Equal(cmd.Payload(), data) {
+		t.Error("payload was not decompressed")
+	}
+	if _, ok := cmd.Key("o"); ok {
+		t.Error("decompressed command should not carry the o key")
+	}
+
+	cmd, err = NewDecoder(strings.NewReader(encoded)).Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if !bytes.Equal(cmd.Payload(), compressed) {
+		t.Error("payload should stay compressed by default")
+	}
+}
+
+// TestDecoderUnexpectedEOF tests truncated streams
+func TestDecoderUnexpectedEOF(t *testing.T) {
+	tests := []string{
+		"\x1b_Ga=t,i=1",
+		"\x1b_Ga=t,m=1;dGVz\x1b\\",
+	}
+
+	for _, stream := range tests {
+		_, err := NewDecoder(strings.NewReader(stream)).Decode()
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Decode(%q) error = %v, want io.ErrUnexpectedEOF", stream, err)
+		}
+	}
+}
+
+// TestDecoderInvalidSequence tests that decoding continues after a malformed sequence
+func TestDecoderInvalidSequence(t *testing.T) {
+	stream := "\x1b_Ga=t,i1\x1b\\" + DeleteAll().Encode()
+
+	d := NewDecoder(strings.NewReader(stream))
+	if _, err := d.Decode(); err == nil {
+		t.Fatal("expected error for malformed sequence")
+	}
+	cmd, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if cmd.Action() != ActionDelete {
+		t.Errorf("Action() = %q, want %q", cmd.Action(), ActionDelete)
+	}
+}
@@ -75,6 +75,16 @@ func (db *DeleteBuilder) Build() *Command {
 	return db.cmd
 }
 
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (db *DeleteBuilder) BuildChecked() (*Command, error) {
+	cmd := db.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
 // Helper functions for common deletion operations
 
 // DeleteAll deletes all placements and preserves image data.
@@ -0,0 +1,169 @@
+package kgp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// DefaultMaxDeltaRects is the default limit on rectangles uploaded per frame
+const DefaultMaxDeltaRects = 4
+
+// deltaMergeRows is the number of unchanged rows below which two changed
+// areas are uploaded as one rectangle, saving a command.
+const deltaMergeRows = 4
+
+// DeltaEncoder converts consecutive full frames of an animation, such as a
+// screen recording, into frame commands carrying only the pixels that
+// changed. Each frame after the first is based on the one before it
+// (BackgroundFrame) and uploads the bounding boxes of its changed rows at
+// their offsets; an unchanged frame uploads nothing.
+type DeltaEncoder struct {
+	// MaxRects limits the rectangles uploaded per frame; the closest ones
+	// are merged until it is met. Zero means DefaultMaxDeltaRects.
+	MaxRects int
+
+	imageID uint32
+	prev    *image.RGBA
+	frames  uint32
+}
+
+// NewDeltaEncoder creates an encoder for the frames of image imageID.
+func NewDeltaEncoder(imageID uint32) *DeltaEncoder {
+	return &DeltaEncoder{imageID: imageID}
+}
+
+// Frames returns the number of frames encoded so far.
+func (e *DeltaEncoder) Frames() uint32 {
+	return e.frames
+}
+
+// Encode returns the commands adding img as the next frame, shown for gap
+// milliseconds. The first frame is transmitted whole as the root frame
+// without displaying it. Later frames must have the same bounds; each
+// changed rectangle uses CompositionReplace when it contains transparent
+// pixels, which blending would mix with the previous frame. Start playback
+// with NewAnimate once the frames are sent.
+func (e *DeltaEncoder) Encode(img image.Image, gap uint32) ([]*Command, error) {
+	cur := image.NewRGBA(img.Bounds())
+	draw.Draw(cur, cur.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	if e.prev == nil {
+		data, err := CompressZlib(cur.Pix)
+		if err != nil {
+			return nil, err
+		}
+		e.prev = cur
+		e.frames = 1
+		return []*Command{
+			NewTransmit().
+				ImageID(e.imageID).
+				Format(FormatRGBA).
+				Dimensions(cur.Rect.Dx(), cur.Rect.Dy()).
+				Compress().
+				TransmitDirect(data).
+				Build(),
+			NewFrame(e.imageID).FrameNumber(1).Gap(gap).Build(),
+		}, nil
+	}
+	if cur.Rect.Size() != e.prev.Rect.Size() {
+		return nil, errors.New("delta frame size differs from the first frame")
+	}
+	// Compare in the coordinates of the first frame
+	cur.Rect = e.prev.Rect
+
+	maxRects := e.MaxRects
+	if maxRects <= 0 {
+		maxRects = DefaultMaxDeltaRects
+	}
+	rects := mergeRects(changedRects(e.prev, cur), maxRects)
+
+	frame := e.frames + 1
+	var cmds []*Command
+	for i, r := range rects {
+		fb := NewFrame(e.imageID)
+		if i == 0 {
+			fb.BackgroundFrame(e.frames).Gap(gap)
+		} else {
+			// Further rectangles edit the frame created by the first
+			fb.FrameNumber(frame)
+		}
+		pixels := cloneRGBA(cur.SubImage(r).(*image.RGBA))
+		if !pixels.Opaque() {
+			fb.Composition(CompositionReplace)
+		}
+		data, err := CompressZlib(pixels.Pix)
+		if err != nil {
+			return nil, err
+		}
+		fb.Format(FormatRGBA).
+			Dimensions(r.Dx(), r.Dy()).
+			Offset(r.Min.X-cur.Rect.Min.X, r.Min.Y-cur.Rect.Min.Y).
+			Compress().
+			FrameData(data)
+		cmds = append(cmds, fb.Build())
+	}
+	if len(cmds) == 0 {
+		// Unchanged: a frame without data copies its background frame
+		cmds = append(cmds, NewFrame(e.imageID).BackgroundFrame(e.frames).Gap(gap).Build())
+	}
+
+	e.prev = cur
+	e.frames = frame
+	return cmds, nil
+}
+
+// changedRects returns the bounding boxes of runs of changed rows, top to
+// bottom. Runs separated by fewer than deltaMergeRows unchanged rows share a
+// box.
+func changedRects(prev, cur *image.RGBA) []image.Rectangle {
+	b := cur.Rect
+	var rects []image.Rectangle
+	var box image.Rectangle
+	unchanged := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		p := prev.Pix[prev.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		c := cur.Pix[cur.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		if bytes.Equal(p, c) {
+			unchanged++
+			if !box.Empty() && unchanged >= deltaMergeRows {
+				rects = append(rects, box)
+				box = image.Rectangle{}
+			}
+			continue
+		}
+		unchanged = 0
+
+		left := 0
+		for p[left] == c[left] {
+			left++
+		}
+		right := len(c)
+		for p[right-1] == c[right-1] {
+			right--
+		}
+		row := image.Rect(b.Min.X+left/4, y, b.Min.X+(right+3)/4, y+1)
+		box = box.Union(row)
+	}
+	if !box.Empty() {
+		rects = append(rects, box)
+	}
+	return rects
+}
+
+// mergeRects merges vertically adjacent rectangles, closest first, until at
+// most limit remain.
+func mergeRects(rects []image.Rectangle, limit int) []image.Rectangle {
+	for len(rects) > limit {
+		closest := 0
+		for i := 1; i < len(rects)-1; i++ {
+			if rects[i+1].Min.Y-rects[i].Max.Y < rects[closest+1].Min.Y-rects[closest].Max.Y {
+				closest = i
+			}
+		}
+		rects[closest] = rects[closest].Union(rects[closest+1])
+		rects = append(rects[:closest+1], rects[closest+2:]...)
+	}
+	return rects
+}
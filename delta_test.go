@@ -0,0 +1,114 @@
+package kgp
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// deltaFrame returns a red 16x16 frame with the given pixels changed to c
+func deltaFrame(c color.Color, points ...image.Point) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, gifRed)
+		}
+	}
+	for _, p := range points {
+		img.Set(p.X, p.Y, c)
+	}
+	return img
+}
+
+// TestDeltaEncoder tests encoding only the changed rectangles of each frame
+func TestDeltaEncoder(t *testing.T) {
+	e := NewDeltaEncoder(5)
+
+	cmds, err := e.Encode(deltaFrame(gifRed), 40)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands for the root frame, got %d", len(cmds))
+	}
+	checkKeys(t, "root", cmds[0], map[string]string{"a": "t", "i": "5", "s": "16", "v": "16"})
+	checkKeys(t, "root gap", cmds[1], map[string]string{"r": "1", "z": "40"})
+
+	// Two changed areas far apart upload two rectangles
+	cmds, err = e.Encode(deltaFrame(gifBlue, image.Pt(2, 0), image.Pt(5, 1), image.Pt(9, 12)), 50)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(cmds))
+	}
+	checkKeys(t, "first rect", cmds[0], map[string]string{"c": "1", "r": "", "z": "50", "x": "2", "y": "0", "s": "4", "v": "2", "X": ""})
+	checkKeys(t, "second rect", cmds[1], map[string]string{"c": "", "r": "2", "z": "", "x": "9", "y": "12", "s": "1", "v": "1"})
+
+	// An unchanged frame uploads nothing
+	cmds, err = e.Encode(deltaFrame(gifBlue, image.Pt(2, 0), image.Pt(5, 1), image.Pt(9, 12)), 60)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	if len(cmds) != 1 || len(cmds[0].Payload()) != 0 {
+		t.Fatalf("expected one empty frame, got %d commands", len(cmds))
+	}
+	checkKeys(t, "unchanged", cmds[0], map[string]string{"c": "2", "z": "60"})
+
+	// Transparent pixels replace instead of blending
+	cmds, err = e.Encode(deltaFrame(gifTransparent, image.Pt(2, 0), image.Pt(5, 1), image.Pt(9, 12)), 60)
+	if err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+	for i, cmd := range cmds {
+		checkKeys(t, "transparent", cmd, map[string]string{"X": "1"})
+		if err := cmd.Validate(); err != nil {
+			t.Errorf("command %d invalid: %v", i, err)
+		}
+	}
+	if e.Frames() != 4 {
+		t.Errorf("Frames() = %d, want 4", e.Frames())
+	}
+}
+
+// TestDeltaEncoder_MaxRects tests merging rectangles down to the limit
+func TestDeltaEncoder_MaxRects(t *testing.T) {
+	e := NewDeltaEncoder(5)
+	e.MaxRects = 1
+	if _, err := e.Encode(deltaFrame(gifRed), 40); err != nil {
+		t.Fatal(err)
+	}
+	cmds, err := e.Encode(deltaFrame(gifBlue, image.Pt(2, 0), image.Pt(9, 12)), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(cmds))
+	}
+	checkKeys(t, "merged", cmds[0], map[string]string{"x": "2", "y": "0", "s": "8", "v": "13"})
+}
+
+// TestDeltaEncoder_SizeMismatch tests rejection of frames of another size
+func TestDeltaEncoder_SizeMismatch(t *testing.T) {
+	e := NewDeltaEncoder(5)
+	if _, err := e.Encode(deltaFrame(gifRed), 40); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Encode(image.NewRGBA(image.Rect(0, 0, 8, 8)), 40); err == nil {
+		t.Error("expected error for a frame of another size")
+	}
+}
+
+// TestMergeRects tests merging the closest rectangles first
+func TestMergeRects(t *testing.T) {
+	rects := []image.Rectangle{
+		image.Rect(0, 0, 4, 2),
+		image.Rect(0, 10, 4, 12),
+		image.Rect(0, 13, 4, 14),
+	}
+	got := mergeRects(rects, 2)
+	want := []image.Rectangle{image.Rect(0, 0, 4, 2), image.Rect(0, 10, 4, 14)}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("mergeRects = %v, want %v", got, want)
+	}
+}
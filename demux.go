@@ -0,0 +1,219 @@
+package kgp
+
+import (
+	"io"
+	"time"
+)
+
+// responseBuffer is the capacity of the InputDemux response channel.
+const responseBuffer = 64
+
+// maxResponseLen bounds the size of a graphics response. Longer sequences
+// cannot be terminal replies and are passed through unchanged.
+const maxResponseLen = 4096
+
+// escapeHoldTimeout is how long an ESC or ESC _ at the end of the input is
+// held back waiting for the rest of a graphics response. Terminals write
+// responses at once, so an ESC followed by nothing is the Escape key.
+const escapeHoldTimeout = 50 * time.Millisecond
+
+// demuxState is the position of the InputDemux scanner within an escape sequence.
+type demuxState int
+
+const (
+	demuxGround     demuxState = iota // passing bytes through
+	demuxEscape                       // after ESC
+	demuxAPC                          // after ESC _
+	demuxGraphics                     // inside ESC _ G
+	demuxGraphicsST                   // after ESC inside ESC _ G
+)
+
+// InputDemux separates graphics responses from other terminal input. It
+// wraps the reader of a tty: graphics responses (ESC_G...ESC\) are removed
+// from the stream, parsed and delivered on the Responses channel, while all
+// other bytes, including keystrokes and other escape sequences, are passed
+// through unchanged to Read. Sequences may be split across reads of the
+// underlying reader.
+//
+// Input is only scanned while Read is called. An ESC at the end of a read is
+// held back until the following byte shows whether it starts a graphics
+// response, or passed through if no more input arrives within 50ms.
+type InputDemux struct {
+	src       io.Reader
+	responses chan *Response
+	buf       []byte
+	out       []byte
+	pos       int
+	held      []byte
+	state     demuxState
+	err       error
+	pending   chan demuxResult // read still running after a hold timeout
+}
+
+// demuxResult is the outcome of a read of the underlying reader.
+type demuxResult struct {
+	n   int
+	err error
+}
+
+// NewInputDemux creates a demultiplexer reading from r.
+func NewInputDemux(r io.Reader) *InputDemux {
+	return &InputDemux{
+		src:       r,
+		responses: make(chan *Response, responseBuffer),
+		buf:       make([]byte, 4096),
+	}
+}
+
+// Responses returns the channel on which parsed graphics responses are
+// delivered. Responses that arrive while the channel is full are dropped.
+// The channel is closed when the underlying reader returns an error.
+func (d *InputDemux) Responses() <-chan *Response {
+	return d.responses
+}
+
+// Read reads input with graphics responses removed.
+func (d *InputDemux) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if d.pos < len(d.out) {
+			n := copy(p, d.out[d.pos:])
+			d.pos += n
+			return n, nil
+		}
+		d.out = d.out[:0]
+		d.pos = 0
+
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		r, ok := d.fill()
+		if !ok {
+			// Nothing followed the ESC: pass it through as a keystroke
+			d.release()
+			continue
+		}
+		for _, b := range d.buf[:r.n] {
+			d.scan(b)
+		}
+		if err := r.err; err != nil {
+			// An incomplete sequence at the end of input was not a response
+			d.out = append(d.out, d.held...)
+			d.held = d.held[:0]
+			d.state = demuxGround
+			d.err = err
+			close(d.responses)
+		}
+	}
+}
+
+// fill reads from the underlying reader into buf. While a lone ESC or ESC _
+// is held the read runs in the background and fill gives up after
+// escapeHoldTimeout, returning false; the next call waits for that read.
+func (d *InputDemux) fill() (demuxResult, bool) {
+	if d.pending == nil && d.state != demuxEscape && d.state != demuxAPC {
+		n, err := d.src.Read(d.buf)
+		return demuxResult{n, err}, true
+	}
+
+	if d.pending == nil {
+		d.pending = make(chan demuxResult, 1)
+		go func(ch chan<- demuxResult) {
+			n, err := d.src.Read(d.buf)
+			ch <- demuxResult{n, err}
+		}(d.pending)
+	}
+
+	var r demuxResult
+	if d.state == demuxEscape || d.state == demuxAPC {
+		timer := time.NewTimer(escapeHoldTimeout)
+		defer timer.Stop()
+		select {
+		case r = <-d.pending:
+		case <-timer.C:
+			return demuxResult{}, false
+		}
+	} else {
+		r = <-d.pending
+	}
+	d.pending = nil
+	return r, true
+}
+
+func (d *InputDemux) scan(b byte) {
+	switch d.state {
+	case demuxGround:
+		if b == '\x1b' {
+			d.hold(b, demuxEscape)
+			return
+		}
+		d.out = append(d.out, b)
+
+	case demuxEscape:
+		if b == '_' {
+			d.hold(b, demuxAPC)
+			return
+		}
+		d.release()
+		d.scan(b)
+
+	case demuxAPC:
+		if b == 'G' {
+			d.hold(b, demuxGraphics)
+			return
+		}
+		d.release()
+		d.scan(b)
+
+	case demuxGraphics:
+		if len(d.held) >= maxResponseLen {
+			d.release()
+			d.scan(b)
+			return
+		}
+		if b == '\x1b' {
+			d.hold(b, demuxGraphicsST)
+			return
+		}
+		d.held = append(d.held, b)
+
+	case demuxGraphicsST:
+		if b == '\\' {
+			d.held = append(d.held, b)
+			d.deliver()
+			return
+		}
+		d.state = demuxGraphics
+		d.scan(b)
+	}
+}
+
+// hold appends b to the bytes of a possible graphics response.
+func (d *InputDemux) hold(b byte, state demuxState) {
+	d.held = append(d.held, b)
+	d.state = state
+}
+
+// release passes the held bytes through; they did not form a response.
+func (d *InputDemux) release() {
+	d.out = append(d.out, d.held...)
+	d.held = d.held[:0]
+	d.state = demuxGround
+}
+
+// deliver parses the held response and sends it on the channel.
+func (d *InputDemux) deliver() {
+	resp, err := parseResponse(string(d.held), false)
+	d.held = d.held[:0]
+	d.state = demuxGround
+	if err != nil {
+		return
+	}
+	select {
+	case d.responses <- resp:
+	default:
+	}
+}
@@ -0,0 +1,165 @@
+package kgp
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// TestInputDemux tests separating responses from other input
+func TestInputDemux(t *testing.T) {
+	input := "ab\x1b[A\x1b_Gi=1;OK\x1b\\cd\x1b_Xapc\x1b\\\x1b\x1b_Gi=2,p=3;ENOENT:gone\x1b\\\x1bOPef"
+	want := "ab\x1b[Acd\x1b_Xapc\x1b\\\x1b\x1bOPef"
+
+	for name, r := range map[string]io.Reader{
+		"Whole":    strings.NewReader(input),
+		"OneByte":  iotest.OneByteReader(strings.NewReader(input)),
+		"HalfRead": iotest.HalfReader(strings.NewReader(input)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewInputDemux(r)
+			got, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatalf("ReadAll error: %v", err)
+			}
+			if string(got) != want {
+				t.Errorf("passthrough = %q, want %q", got, want)
+			}
+
+			var resps []*Response
+			for resp := range d.Responses() {
+				resps = append(resps, resp)
+			}
+			if len(resps) != 2 {
+				t.Fatalf("got %d responses, want 2", len(resps))
+			}
+			if !resps[0].Success || resps[0].ImageID != 1 {
+				t.Errorf("unexpected first response: %+v", resps[0])
+			}
+			if resps[1].ErrorCode != "ENOENT" || resps[1].PlacementID != 3 {
+				t.Errorf("unexpected second response: %+v", resps[1])
+			}
+		})
+	}
+}
+
+// TestInputDemuxIncompleteSequence tests that a truncated response is passed through
+func TestInputDemuxIncompleteSequence(t *testing.T) {
+	d := NewInputDemux(strings.NewReader("x\x1b_Gi=1;O"))
+	got, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if string(got) != "x\x1b_Gi=1;O" {
+		t.Errorf("passthrough = %q", got)
+	}
+	if _, ok := <-d.Responses(); ok {
+		t.Error("expected closed channel without responses")
+	}
+}
+
+// TestInputDemuxOversized tests that overlong graphics sequences are passed through
+func TestInputDemuxOversized(t *testing.T) {
+	long := "\x1b_G" + strings.Repeat("a", maxResponseLen+10) + "\x1b\\"
+	got, err := io.ReadAll(NewInputDemux(strings.NewReader(long)))
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if string(got) != long {
+		t.Error("oversized sequence should be passed through unchanged")
+	}
+}
+
+// TestInputDemuxSmallReads tests reading into buffers smaller than the input
+func TestInputDemuxSmallReads(t *testing.T) {
+	d := NewInputDemux(strings.NewReader("hello\x1b_G;OK\x1b\\world"))
+	var sb strings.Builder
+	p := make([]byte, 3)
+	for {
+		n, err := d.Read(p)
+		sb.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+	if sb.String() != "helloworld" {
+		t.Errorf("got %q, want %q", sb.String(), "helloworld")
+	}
+}
+
+// TestInputDemuxLoneEscape tests that an Escape keypress is passed through
+// without waiting for further input
+func TestInputDemuxLoneEscape(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	d := NewInputDemux(r)
+
+	read := func(want string) {
+		t.Helper()
+		got := make(chan string, 1)
+		go func() {
+			p := make([]byte, 16)
+			n, _ := d.Read(p)
+			got <- string(p[:n])
+		}()
+		select {
+		case s := <-got:
+			if s != want {
+				t.Errorf("Read = %q, want %q", s, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Read of %q blocked", want)
+		}
+	}
+
+	go io.WriteString(w, "\x1b")
+	read("\x1b")
+
+	// The read left running after the timeout delivers the next input
+	go io.WriteString(w, "x\x1b_Gi=1;OK\x1b\\y")
+	read("xy")
+	if resp := <-d.Responses(); resp.ImageID != 1 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+// TestSessionWithInputDemux tests a session fed from an InputDemux
+func TestSessionWithInputDemux(t *testing.T) {
+	respR, respW := io.Pipe()
+	d := NewInputDemux(respR)
+	cmdR, cmdW := io.Pipe()
+	s := NewSessionWithResponses(cmdW, d.Responses())
+
+	go func() {
+		dec := NewDecoder(cmdR)
+		cmd, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		io.WriteString(respW, "key"+echoReply(cmd)+"more")
+		respW.Close()
+	}()
+
+	keys := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(d)
+		keys <- string(b)
+	}()
+
+	resp, err := s.Send(context.Background(), NewPut(5).Build())
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if resp.ImageID != 5 {
+		t.Errorf("ImageID = %d, want 5", resp.ImageID)
+	}
+	if got := <-keys; got != "keymore" {
+		t.Errorf("keyboard input = %q, want %q", got, "keymore")
+	}
+}
@@ -0,0 +1,302 @@
+package kgp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+)
+
+// Capabilities describes the graphics support detected by Detect.
+type Capabilities struct {
+	// Graphics reports whether the terminal answered graphics queries at all.
+	Graphics bool
+	// Formats reports, for each probed format, whether it was accepted.
+	Formats map[Format]bool
+	// Media reports, for each probed transmission medium, whether it was accepted.
+	Media map[TransmitMedium]bool
+	// Terminal is the name and version reported by XTVERSION, if any.
+	Terminal string
+	// DeviceAttributes holds the parameters of the primary device attributes reply.
+	DeviceAttributes string
+}
+
+// Preferred returns the most efficient supported medium and a matching
+// format: shared memory, temporary file or file with raw RGBA when the
+// terminal can read local data, otherwise direct transmission with PNG
+// (falling back to RGBA) to keep the data small.
+func (c Capabilities) Preferred() (TransmitMedium, Format) {
+	for _, m := range []TransmitMedium{TransmitSharedMem, TransmitTemp, TransmitFile} {
+		if c.Media[m] && c.Formats[FormatRGBA] {
+			return m, FormatRGBA
+		}
+	}
+	if c.Formats[FormatPNG] {
+		return TransmitDirect, FormatPNG
+	}
+	return TransmitDirect, FormatRGBA
+}
+
+// probe is a single graphics query sent by Detect.
+type probe struct {
+	id      uint32
+	format  Format
+	medium  TransmitMedium
+	cmd     *Command
+	cleanup func()
+}
+
+// Detect probes the terminal for graphics support. It sends an a=q query for
+// each Format and each TransmitMedium, creating a real file, temporary file
+// and shared memory object for the non-direct media, followed by an
+// XTVERSION request and a primary device attributes request. Every terminal
+// answers the latter, so Detect returns as soon as it arrives, without
+// waiting for a timeout when the terminal ignores graphics queries.
+//
+// rw is typically the tty in raw mode. Probe files and shared memory objects
+// are removed before Detect returns. If ctx ends before the device attributes
+// arrive, the capabilities detected so far are returned with ctx.Err(); the
+// goroutine reading rw then runs until its next read returns.
+func Detect(ctx context.Context, rw io.ReadWriter) (Capabilities, error) {
+	caps := Capabilities{
+		Formats: make(map[Format]bool),
+		Media:   make(map[TransmitMedium]bool),
+	}
+
+	probes, err := newProbes()
+	if err != nil {
+		return caps, err
+	}
+	defer func() {
+		for _, p := range probes {
+			if p.cleanup != nil {
+				p.cleanup()
+			}
+		}
+	}()
+
+	var req []byte
+	for _, p := range probes {
+		req = p.cmd.AppendEncode(req)
+	}
+	req = append(req, "\x1b[>0q"...) // XTVERSION
+	req = append(req, "\x1b[c"...)   // Primary device attributes
+	if _, err := rw.Write(req); err != nil {
+		return caps, err
+	}
+
+	replies := make(chan reply)
+	done := make(chan struct{})
+	defer close(done)
+	go readReplies(rw, replies, done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return caps, ctx.Err()
+		case r, ok := <-replies:
+			if !ok {
+				return caps, io.ErrUnexpectedEOF
+			}
+			switch r.kind {
+			case 'G':
+				resp, err := parseResponse(r.body, false)
+				if err != nil {
+					continue
+				}
+				for _, p := range probes {
+					if p.id != resp.ImageID {
+						continue
+					}
+					caps.Graphics = true
+					if p.format != 0 {
+						caps.Formats[p.format] = resp.Success
+					}
+					if p.medium != "" {
+						caps.Media[p.medium] = resp.Success
+					}
+				}
+			case 'P':
+				if name, ok := strings.CutPrefix(r.body, ">|"); ok {
+					caps.Terminal = name
+				}
+			case 'c':
+				if attrs, ok := strings.CutPrefix(r.body, "?"); ok {
+					caps.DeviceAttributes = attrs
+					return caps, nil
+				}
+			}
+		}
+	}
+}
+
+// newProbes builds the format and medium queries. Media whose data source
+// cannot be created are not probed.
+func newProbes() ([]*probe, error) {
+	base := rand.Uint32N(1<<24) + 1<<24
+	pixel := []byte{0, 0, 0}
+
+	pngData, err := ImageToPNG(image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		return nil, err
+	}
+
+	probes := []*probe{
+		{format: FormatRGB, cmd: NewQuery().Format(FormatRGB).Dimensions(1, 1).TestData(pixel).Build()},
+		{format: FormatRGBA, cmd: NewQuery().Format(FormatRGBA).Dimensions(1, 1).TestData([]byte{0, 0, 0, 0}).Build()},
+		{format: FormatPNG, cmd: NewQuery().Format(FormatPNG).TestData(pngData).Build()},
+		{medium: TransmitDirect, cmd: NewQuery().Format(FormatRGB).Dimensions(1, 1).TransmitMedium(TransmitDirect).TestData(pixel).Build()},
+	}
+
+	if f, err := os.CreateTemp("", "kgp-probe-*"); err == nil {
+		_, werr := f.Write(pixel)
+		f.Close()
+		if werr == nil {
+			probes = append(probes, fileProbe(TransmitFile, f.Name()))
+		} else {
+			os.Remove(f.Name())
+		}
+	}
+
+	if f, err := os.CreateTemp("", "tty-graphics-protocol-*"); err == nil {
+		_, werr := f.Write(pixel)
+		f.Close()
+		if werr == nil {
+			probes = append(probes, fileProbe(TransmitTemp, f.Name()))
+		} else {
+			os.Remove(f.Name())
+		}
+	}
+
+	shmName := fmt.Sprintf("/kgp-probe-%d-%d", os.Getpid(), base)
+	if createSharedMemory(shmName, pixel) == nil {
+		probes = append(probes, &probe{
+			medium:  TransmitSharedMem,
+			cmd:     NewQuery().Format(FormatRGB).Dimensions(1, 1).TransmitMedium(TransmitSharedMem).TestData([]byte(shmName)).Build().SetKeyInt("S", len(pixel)),
+			cleanup: func() { unlinkSharedMemory(shmName) },
+		})
+	}
+
+	for i, p := range probes {
+		p.id = base + uint32(i)
+		p.cmd.SetKeyUint32("i", p.id)
+	}
+	return probes, nil
+}
+
+func fileProbe(medium TransmitMedium, path string) *probe {
+	return &probe{
+		medium:  medium,
+		cmd:     NewQuery().Format(FormatRGB).Dimensions(1, 1).TransmitMedium(medium).TestData([]byte(path)).Build(),
+		cleanup: func() { os.Remove(path) },
+	}
+}
+
+// reply is an escape sequence received from the terminal. kind is 'G' for
+// graphics responses, 'P' for DCS strings and the final byte for CSI
+// sequences; body is the content between introducer and terminator.
+type reply struct {
+	kind byte
+	body string
+}
+
+// readReplies sends the escape sequences read from r on replies until the
+// primary device attributes reply, a read error, or done is closed. It closes
+// replies when it returns.
+func readReplies(r io.Reader, replies chan<- reply, done <-chan struct{}) {
+	defer close(replies)
+	br := bufio.NewReader(byteAtATime{r})
+
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		if b != '\x1b' {
+			continue
+		}
+		b, err = br.ReadByte()
+		if err != nil {
+			return
+		}
+
+		var rep reply
+		switch b {
+		case '_', 'P':
+			body, err := readString(br)
+			if err != nil {
+				return
+			}
+			if b == 'P' {
+				rep = reply{kind: 'P', body: body}
+			} else if graphics, ok := strings.CutPrefix(body, "G"); ok {
+				rep = reply{kind: 'G', body: graphics}
+			} else {
+				continue
+			}
+		case '[':
+			var params []byte
+			for {
+				c, err := br.ReadByte()
+				if err != nil {
+					return
+				}
+				if c >= 0x40 && c <= 0x7e {
+					rep = reply{kind: c, body: string(params)}
+					break
+				}
+				params = append(params, c)
+			}
+		default:
+			br.UnreadByte()
+			continue
+		}
+
+		select {
+		case replies <- rep:
+		case <-done:
+			return
+		}
+		if rep.kind == 'c' && strings.HasPrefix(rep.body, "?") {
+			return
+		}
+	}
+}
+
+// byteAtATime limits reads to a single byte, so no input following the
+// final reply is consumed from the tty.
+type byteAtATime struct {
+	r io.Reader
+}
+
+func (b byteAtATime) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// readString reads an APC or DCS string up to the ESC\ terminator.
+func readString(br *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	for {
+		s, err := br.ReadString('\x1b')
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s[:len(s)-1])
+		b, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\\' {
+			return sb.String(), nil
+		}
+		sb.WriteByte('\x1b')
+		br.UnreadByte()
+	}
+}
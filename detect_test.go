@@ -0,0 +1,179 @@
+package kgp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTTY is an io.ReadWriter whose replies are generated from the requests written to it.
+type fakeTTY struct {
+	respond func(req []byte) string
+	replies io.Reader
+	pw      *io.PipeWriter
+}
+
+func newFakeTTY(respond func(req []byte) string) *fakeTTY {
+	pr, pw := io.Pipe()
+	return &fakeTTY{respond: respond, replies: pr, pw: pw}
+}
+
+func (f *fakeTTY) Write(p []byte) (int, error) {
+	reply := f.respond(append([]byte(nil), p...))
+	go io.WriteString(f.pw, reply)
+	return len(p), nil
+}
+
+func (f *fakeTTY) Read(p []byte) (int, error) {
+	return f.replies.Read(p)
+}
+
+// kittyLike answers graphics queries, rejecting the media in unsupported
+func kittyLike(t *testing.T, unsupported map[TransmitMedium]bool) func(req []byte) string {
+	return func(req []byte) string {
+		var sb strings.Builder
+		d := NewDecoder(bytes.NewReader(req))
+		for {
+			cmd, err := d.Decode()
+			if err != nil {
+				break
+			}
+			id, _ := cmd.Key("i")
+			medium, _ := cmd.Key("t")
+			if medium == string(TransmitFile) || medium == string(TransmitTemp) {
+				if _, err := os.Stat(string(cmd.Payload())); err != nil {
+					t.Errorf("probe file %q does not exist: %v", cmd.Payload(), err)
+				}
+			}
+			if unsupported[TransmitMedium(medium)] {
+				fmt.Fprintf(&sb, "\x1b_Gi=%s;EBADF:cannot read\x1b\\", id)
+			} else {
+				fmt.Fprintf(&sb, "\x1b_Gi=%s;OK\x1b\\", id)
+			}
+		}
+		if bytes.Contains(req, []byte("\x1b[>0q")) {
+			sb.WriteString("\x1bP>|kitty(0.35.2)\x1b\\")
+		}
+		if bytes.Contains(req, []byte("\x1b[c")) {
+			sb.WriteString("\x1b[?62;c")
+		}
+		return sb.String()
+	}
+}
+
+// TestDetect tests detecting capabilities of a kitty-like terminal
+func TestDetect(t *testing.T) {
+	tty := newFakeTTY(kittyLike(t, map[TransmitMedium]bool{TransmitSharedMem: true}))
+
+	caps, err := Detect(context.Background(), tty)
+	if err != nil {
+		t.Fatalf("Detect error: %v", err)
+	}
+	if !caps.Graphics {
+		t.Error("Graphics should be true")
+	}
+	for _, f := range []Format{FormatRGB, FormatRGBA, FormatPNG} {
+		if !caps.Formats[f] {
+			t.Errorf("format %d should be supported", f)
+		}
+	}
+	for _, m := range []TransmitMedium{TransmitDirect, TransmitFile, TransmitTemp} {
+		if !caps.Media[m] {
+			t.Errorf("medium %q should be supported", m)
+		}
+	}
+	if caps.Media[TransmitSharedMem] {
+		t.Error("shared memory should not be supported")
+	}
+	if caps.Terminal != "kitty(0.35.2)" {
+		t.Errorf("Terminal = %q", caps.Terminal)
+	}
+	if caps.DeviceAttributes != "62;" {
+		t.Errorf("DeviceAttributes = %q", caps.DeviceAttributes)
+	}
+	if m, f := caps.Preferred(); m != TransmitTemp || f != FormatRGBA {
+		t.Errorf("Preferred() = %q, %d; want temp file with RGBA", m, f)
+	}
+}
+
+// TestDetectNoGraphics tests a terminal that only answers device attributes
+func TestDetectNoGraphics(t *testing.T) {
+	tty := newFakeTTY(func(req []byte) string { return "\x1b[?1;2c" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	caps, err := Detect(ctx, tty)
+	if err != nil {
+		t.Fatalf("Detect error: %v", err)
+	}
+	if caps.Graphics || len(caps.Formats) != 0 || len(caps.Media) != 0 {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+	if m, f := caps.Preferred(); m != TransmitDirect || f != FormatRGBA {
+		t.Errorf("Preferred() = %q, %d", m, f)
+	}
+}
+
+// TestDetectRemote tests a terminal that cannot read local files, as over SSH
+func TestDetectRemote(t *testing.T) {
+	tty := newFakeTTY(kittyLike(t, map[TransmitMedium]bool{
+		TransmitFile:      true,
+		TransmitTemp:      true,
+		TransmitSharedMem: true,
+	}))
+
+	caps, err := Detect(context.Background(), tty)
+	if err != nil {
+		t.Fatalf("Detect error: %v", err)
+	}
+	if m, f := caps.Preferred(); m != TransmitDirect || f != FormatPNG {
+		t.Errorf("Preferred() = %q, %d; want direct PNG", m, f)
+	}
+}
+
+// TestDetectTimeout tests a terminal that never answers
+func TestDetectTimeout(t *testing.T) {
+	tty := newFakeTTY(func(req []byte) string { return "" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Detect(ctx, tty); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+// TestDetectCleanup tests that probe files are removed
+func TestDetectCleanup(t *testing.T) {
+	var paths []string
+	tty := newFakeTTY(func(req []byte) string {
+		d := NewDecoder(bytes.NewReader(req))
+		for {
+			cmd, err := d.Decode()
+			if err != nil {
+				break
+			}
+			if m, _ := cmd.Key("t"); m == string(TransmitFile) || m == string(TransmitTemp) {
+				paths = append(paths, string(cmd.Payload()))
+			}
+		}
+		return "\x1b[?62;c"
+	})
+
+	if _, err := Detect(context.Background(), tty); err != nil {
+		t.Fatalf("Detect error: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 probe files, got %d", len(paths))
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("probe file %q was not removed", p)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package kgp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors for the error codes a terminal reports in responses.
+// Use errors.Is to match them against the error returned by Response.Err.
+var (
+	// ErrNotFound indicates the referenced image, placement or frame does not exist (ENOENT)
+	ErrNotFound = errors.New("ENOENT")
+	// ErrInvalidArgument indicates invalid or inconsistent control data (EINVAL)
+	ErrInvalidArgument = errors.New("EINVAL")
+	// ErrBadFile indicates the transmission file or shared memory could not be read (EBADF)
+	ErrBadFile = errors.New("EBADF")
+	// ErrNoData indicates insufficient image data was supplied (ENODATA)
+	ErrNoData = errors.New("ENODATA")
+	// ErrTooLarge indicates the image data or dimensions are too large (EFBIG)
+	ErrTooLarge = errors.New("EFBIG")
+	// ErrNoSpace indicates the terminal's storage quota was exceeded (ENOSPC)
+	ErrNoSpace = errors.New("ENOSPC")
+	// ErrPermission indicates the terminal refused to read the data source (EPERM)
+	ErrPermission = errors.New("EPERM")
+	// ErrIO indicates an I/O error while reading the data source (EIO)
+	ErrIO = errors.New("EIO")
+	// ErrNoMemory indicates the terminal ran out of memory (ENOMEM)
+	ErrNoMemory = errors.New("ENOMEM")
+	// ErrTooDeep indicates a chain of relative placements is too deep (ETOODEEP)
+	ErrTooDeep = errors.New("ETOODEEP")
+	// ErrCycle indicates relative placements would form a cycle (ECYCLE)
+	ErrCycle = errors.New("ECYCLE")
+	// ErrNoParent indicates the parent of a relative placement does not exist (ENOPARENT)
+	ErrNoParent = errors.New("ENOPARENT")
+)
+
+var errorCodes = map[string]error{
+	"ENOENT":    ErrNotFound,
+	"EINVAL":    ErrInvalidArgument,
+	"EBADF":     ErrBadFile,
+	"ENODATA":   ErrNoData,
+	"EFBIG":     ErrTooLarge,
+	"ENOSPC":    ErrNoSpace,
+	"EPERM":     ErrPermission,
+	"EIO":       ErrIO,
+	"ENOMEM":    ErrNoMemory,
+	"ETOODEEP":  ErrTooDeep,
+	"ECYCLE":    ErrCycle,
+	"ENOPARENT": ErrNoParent,
+}
+
+// ResponseError is the error reported by a terminal response. It wraps the
+// sentinel error for its code, so errors.Is(err, ErrNotFound) matches an
+// ENOENT response.
+type ResponseError struct {
+	Code        string
+	Message     string
+	ImageID     uint32
+	ImageNumber uint32
+	PlacementID uint32
+}
+
+// Error returns the error code and message along with the IDs from the response.
+func (e *ResponseError) Error() string {
+	var sb strings.Builder
+
+	if e.Code != "" {
+		sb.WriteString(e.Code)
+	} else {
+		sb.WriteString("unknown error")
+	}
+	if e.Message != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Message)
+	}
+
+	var ids []string
+	if e.ImageID != 0 {
+		ids = append(ids, fmt.Sprintf("image %d", e.ImageID))
+	}
+	if e.ImageNumber != 0 {
+		ids = append(ids, fmt.Sprintf("image number %d", e.ImageNumber))
+	}
+	if e.PlacementID != 0 {
+		ids = append(ids, fmt.Sprintf("placement %d", e.PlacementID))
+	}
+	if len(ids) > 0 {
+		sb.WriteString(" (")
+		sb.WriteString(strings.Join(ids, ", "))
+		sb.WriteString(")")
+	}
+
+	return sb.String()
+}
+
+// Unwrap returns the sentinel error for the code, or nil for unknown codes.
+func (e *ResponseError) Unwrap() error {
+	return errorCodes[e.Code]
+}
+
+// Err returns nil for a successful response and a *ResponseError otherwise.
+func (r *Response) Err() error {
+	if r.Success {
+		return nil
+	}
+	return &ResponseError{
+		Code:        r.ErrorCode,
+		Message:     r.Message,
+		ImageID:     r.ImageID,
+		ImageNumber: r.ImageNumber,
+		PlacementID: r.PlacementID,
+	}
+}
+
+// IsNotFound reports whether err is an ENOENT error, for example because the
+// terminal evicted an image.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsNoSpace reports whether err is an ENOSPC error.
+func IsNoSpace(err error) bool {
+	return errors.Is(err, ErrNoSpace)
+}
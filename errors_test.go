@@ -0,0 +1,107 @@
+package kgp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestResponseErr tests converting responses to errors
+func TestResponseErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     error
+		wantText string
+	}{
+		{
+			name:     "Not found",
+			response: "\x1b_Gi=10,p=2;ENOENT:No such image\x1b\\",
+			want:     ErrNotFound,
+			wantText: "ENOENT: No such image (image 10, placement 2)",
+		},
+		{
+			name:     "No space",
+			response: "\x1b_Gi=5;ENOSPC:Storage quota exceeded\x1b\\",
+			want:     ErrNoSpace,
+			wantText: "ENOSPC: Storage quota exceeded (image 5)",
+		},
+		{
+			name:     "Cycle",
+			response: "\x1b_Gi=1,I=3;ECYCLE:cycle\x1b\\",
+			want:     ErrCycle,
+			wantText: "ECYCLE: cycle (image 1, image number 3)",
+		},
+		{
+			name:     "Too deep",
+			response: "\x1b_G;ETOODEEP:chain too long\x1b\\",
+			want:     ErrTooDeep,
+			wantText: "ETOODEEP: chain too long",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ParseResponse(tt.response)
+			if err != nil {
+				t.Fatalf("ParseResponse error: %v", err)
+			}
+			rerr := resp.Err()
+			if !errors.Is(rerr, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", rerr, tt.want)
+			}
+			if rerr.Error() != tt.wantText {
+				t.Errorf("Error() = %q, want %q", rerr.Error(), tt.wantText)
+			}
+
+			var respErr *ResponseError
+			if !errors.As(fmt.Errorf("wrapped: %w", rerr), &respErr) {
+				t.Fatal("errors.As should find *ResponseError")
+			}
+			if respErr.ImageID != resp.ImageID {
+				t.Errorf("ImageID = %d, want %d", respErr.ImageID, resp.ImageID)
+			}
+		})
+	}
+}
+
+// TestResponseErrSuccess tests that successful responses have no error
+func TestResponseErrSuccess(t *testing.T) {
+	resp, err := ParseResponse("\x1b_Gi=10;OK\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseResponse error: %v", err)
+	}
+	if resp.Err() != nil {
+		t.Errorf("Err() = %v, want nil", resp.Err())
+	}
+}
+
+// TestResponseErrUnknownCode tests unknown error codes
+func TestResponseErrUnknownCode(t *testing.T) {
+	resp := &Response{ErrorCode: "EWEIRD", Message: "strange"}
+	rerr := resp.Err()
+	if errors.Unwrap(rerr) != nil {
+		t.Error("unknown code should not wrap a sentinel")
+	}
+	if rerr.Error() != "EWEIRD: strange" {
+		t.Errorf("Error() = %q", rerr.Error())
+	}
+
+	resp = &Response{}
+	if resp.Err().Error() != "unknown error" {
+		t.Errorf("Error() = %q, want %q", resp.Err().Error(), "unknown error")
+	}
+}
+
+// TestErrorHelpers tests the IsNotFound and IsNoSpace helpers
+func TestErrorHelpers(t *testing.T) {
+	notFound := (&Response{ErrorCode: "ENOENT"}).Err()
+	noSpace := (&Response{ErrorCode: "ENOSPC"}).Err()
+
+	if !IsNotFound(notFound) || IsNotFound(noSpace) || IsNotFound(nil) {
+		t.Error("IsNotFound returned unexpected result")
+	}
+	if !IsNoSpace(noSpace) || IsNoSpace(notFound) {
+		t.Error("IsNoSpace returned unexpected result")
+	}
+}
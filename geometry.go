@@ -0,0 +1,146 @@
+package kgp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrNoGeometry indicates the terminal did not report its size in pixels.
+var ErrNoGeometry = errors.New("terminal did not report its pixel geometry")
+
+// Geometry is the size of the terminal window in cells and pixels.
+type Geometry struct {
+	Rows       int // Window height in cells
+	Columns    int // Window width in cells
+	Width      int // Text area width in pixels
+	Height     int // Text area height in pixels
+	CellWidth  int // Cell width in pixels
+	CellHeight int // Cell height in pixels
+}
+
+// complete derives the cell size from the window size, or the window size
+// from the cell size, when only one of them is known.
+func (g *Geometry) complete() {
+	if g.Rows <= 0 || g.Columns <= 0 {
+		return
+	}
+	if (g.CellWidth <= 0 || g.CellHeight <= 0) && g.Width > 0 && g.Height > 0 {
+		g.CellWidth = g.Width / g.Columns
+		g.CellHeight = g.Height / g.Rows
+	}
+	if (g.Width <= 0 || g.Height <= 0) && g.CellWidth > 0 && g.CellHeight > 0 {
+		g.Width = g.CellWidth * g.Columns
+		g.Height = g.CellHeight * g.Rows
+	}
+}
+
+// hasCellSize reports whether the cell size in pixels is known.
+func (g Geometry) hasCellSize() bool {
+	return g.CellWidth > 0 && g.CellHeight > 0
+}
+
+// Cells returns the number of columns and rows needed to display an image of
+// the given size in pixels, for use with DisplaySize. It returns 0, 0 if the
+// cell size is unknown.
+func (g Geometry) Cells(width, height int) (columns, rows int) {
+	if !g.hasCellSize() {
+		return 0, 0
+	}
+	return (width + g.CellWidth - 1) / g.CellWidth, (height + g.CellHeight - 1) / g.CellHeight
+}
+
+// Pixels returns the size in pixels of an area of columns x rows cells.
+func (g Geometry) Pixels(columns, rows int) (width, height int) {
+	return columns * g.CellWidth, rows * g.CellHeight
+}
+
+// Position splits a pixel position within the window into the cell
+// containing it and the pixel offset within that cell, for use with
+// CellOffset. It returns all zeros if the cell size is unknown.
+func (g Geometry) Position(x, y int) (column, row, offsetX, offsetY int) {
+	if !g.hasCellSize() {
+		return 0, 0, 0, 0
+	}
+	return x / g.CellWidth, y / g.CellHeight, x % g.CellWidth, y % g.CellHeight
+}
+
+// TerminalGeometry returns the geometry of the terminal tty. It asks the
+// kernel first (TIOCGWINSZ on Linux) and falls back to QueryGeometry when
+// the kernel does not know the size in pixels, as is common over SSH and
+// in some terminals.
+func TerminalGeometry(ctx context.Context, tty *os.File) (Geometry, error) {
+	g, err := WindowGeometry(tty)
+	if err == nil {
+		return g, nil
+	}
+	return QueryGeometry(ctx, tty)
+}
+
+// QueryGeometry asks the terminal for its geometry with the CSI 14 t (text
+// area in pixels), CSI 16 t (cell size in pixels) and CSI 18 t (text area in
+// cells) requests, followed by a primary device attributes request, which
+// every terminal answers, so that it need not wait for a timeout when the
+// terminal ignores the others.
+//
+// rw is typically the tty in raw mode. Values the terminal does not report
+// are derived from the others where possible; if the cell size remains
+// unknown, the partial geometry is returned with ErrNoGeometry.
+func QueryGeometry(ctx context.Context, rw io.ReadWriter) (Geometry, error) {
+	var g Geometry
+	if _, err := io.WriteString(rw, "\x1b[14t\x1b[16t\x1b[18t\x1b[c"); err != nil {
+		return g, err
+	}
+
+	replies := make(chan reply)
+	done := make(chan struct{})
+	defer close(done)
+	go readReplies(rw, replies, done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return g, ctx.Err()
+		case r, ok := <-replies:
+			if !ok {
+				return g, io.ErrUnexpectedEOF
+			}
+			switch r.kind {
+			case 't':
+				parseWindowReport(&g, r.body)
+			case 'c':
+				if strings.HasPrefix(r.body, "?") {
+					g.complete()
+					if !g.hasCellSize() {
+						return g, ErrNoGeometry
+					}
+					return g, nil
+				}
+			}
+		}
+	}
+}
+
+// parseWindowReport stores the values of a CSI 4/6/8 ; a ; b t report.
+func parseWindowReport(g *Geometry, params string) {
+	fields := strings.Split(params, ";")
+	if len(fields) != 3 {
+		return
+	}
+	a, errA := strconv.Atoi(fields[1])
+	b, errB := strconv.Atoi(fields[2])
+	if errA != nil || errB != nil || a <= 0 || b <= 0 {
+		return
+	}
+	switch fields[0] {
+	case "4":
+		g.Height, g.Width = a, b
+	case "6":
+		g.CellHeight, g.CellWidth = a, b
+	case "8":
+		g.Rows, g.Columns = a, b
+	}
+}
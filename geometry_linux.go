@@ -0,0 +1,51 @@
+//go:build linux
+
+package kgp
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// winsize mirrors struct winsize from <sys/ioctl.h>.
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+// WindowGeometry returns the geometry of the terminal f as reported by the
+// kernel (TIOCGWINSZ). It returns ErrNoGeometry if the kernel does not know
+// the window size in pixels.
+func WindowGeometry(f *os.File) (Geometry, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return Geometry{}, err
+	}
+
+	var ws winsize
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	})
+	if err != nil {
+		return Geometry{}, err
+	}
+	if errno != 0 {
+		return Geometry{}, os.NewSyscallError("ioctl TIOCGWINSZ", errno)
+	}
+
+	g := Geometry{
+		Rows:    int(ws.Row),
+		Columns: int(ws.Col),
+		Width:   int(ws.Xpixel),
+		Height:  int(ws.Ypixel),
+	}
+	g.complete()
+	if !g.hasCellSize() {
+		return g, ErrNoGeometry
+	}
+	return g, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package kgp
+
+import (
+	"errors"
+	"os"
+)
+
+// WindowGeometry returns the geometry of the terminal f as reported by the
+// kernel. It is only implemented on Linux; elsewhere it returns an error and
+// QueryGeometry must be used instead.
+func WindowGeometry(f *os.File) (Geometry, error) {
+	return Geometry{}, errors.New("window geometry is not supported on this platform")
+}
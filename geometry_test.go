@@ -0,0 +1,136 @@
+package kgp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestQueryGeometry tests parsing the window reports of the terminal
+func TestQueryGeometry(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies string
+		want    Geometry
+		wantErr error
+	}{
+		{
+			name:    "all reports",
+			replies: "\x1b[4;800;1600t\x1b[6;20;10t\x1b[8;40;160t\x1b[?62;c",
+			want:    Geometry{Rows: 40, Columns: 160, Width: 1600, Height: 800, CellWidth: 10, CellHeight: 20},
+		},
+		{
+			name:    "cell size derived from window size",
+			replies: "\x1b[4;800;1600t\x1b[8;40;160t\x1b[?62;c",
+			want:    Geometry{Rows: 40, Columns: 160, Width: 1600, Height: 800, CellWidth: 10, CellHeight: 20},
+		},
+		{
+			name:    "window size derived from cell size",
+			replies: "\x1b[6;20;10t\x1b[8;40;160t\x1b[?62;c",
+			want:    Geometry{Rows: 40, Columns: 160, Width: 1600, Height: 800, CellWidth: 10, CellHeight: 20},
+		},
+		{
+			name:    "only cells",
+			replies: "\x1b[8;24;80t\x1b[?1;2c",
+			want:    Geometry{Rows: 24, Columns: 80},
+			wantErr: ErrNoGeometry,
+		},
+		{
+			name:    "no reports",
+			replies: "\x1b[?1;2c",
+			wantErr: ErrNoGeometry,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request string
+			tty := newFakeTTY(func(req []byte) string {
+				request = string(req)
+				return tt.replies
+			})
+
+			got, err := QueryGeometry(context.Background(), tty)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("geometry = %+v, want %+v", got, tt.want)
+			}
+			if !strings.Contains(request, "\x1b[16t") || !strings.HasSuffix(request, "\x1b[c") {
+				t.Errorf("unexpected request %q", request)
+			}
+		})
+	}
+}
+
+// TestQueryGeometryTimeout tests a terminal that never answers
+func TestQueryGeometryTimeout(t *testing.T) {
+	tty := newFakeTTY(func(req []byte) string { return "" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := QueryGeometry(ctx, tty); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+// TestWindowGeometry_NotTerminal tests that a regular file has no window size
+func TestWindowGeometry_NotTerminal(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "geometry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := WindowGeometry(f); err == nil {
+		t.Error("expected error for a regular file")
+	}
+}
+
+// TestGeometry_Cells tests converting pixel sizes to cells
+func TestGeometry_Cells(t *testing.T) {
+	g := Geometry{CellWidth: 10, CellHeight: 20}
+
+	tests := []struct {
+		width, height int
+		cols, rows    int
+	}{
+		{100, 200, 10, 10},
+		{101, 201, 11, 11},
+		{1, 1, 1, 1},
+		{0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		cols, rows := g.Cells(tt.width, tt.height)
+		if cols != tt.cols || rows != tt.rows {
+			t.Errorf("Cells(%d, %d) = %d, %d; want %d, %d", tt.width, tt.height, cols, rows, tt.cols, tt.rows)
+		}
+	}
+
+	if cols, rows := (Geometry{}).Cells(100, 100); cols != 0 || rows != 0 {
+		t.Errorf("Cells with unknown cell size = %d, %d", cols, rows)
+	}
+}
+
+// TestGeometry_Position tests splitting pixel positions into cell and offset
+func TestGeometry_Position(t *testing.T) {
+	g := Geometry{CellWidth: 10, CellHeight: 20}
+
+	col, row, x, y := g.Position(125, 47)
+	if col != 12 || row != 2 || x != 5 || y != 7 {
+		t.Errorf("Position(125, 47) = %d, %d, %d, %d", col, row, x, y)
+	}
+
+	cmd := NewPut(1).CellOffset(x, y).DisplaySize(g.Cells(64, 64)).Build()
+	if cmd.controlData["X"] != "5" || cmd.controlData["Y"] != "7" || cmd.controlData["c"] != "7" || cmd.controlData["r"] != "4" {
+		t.Errorf("unexpected control data %v", cmd.controlData)
+	}
+
+	if w, h := g.Pixels(3, 2); w != 30 || h != 40 {
+		t.Errorf("Pixels(3, 2) = %d, %d", w, h)
+	}
+}
@@ -0,0 +1,79 @@
+package kgp
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+// defaultGIFDelay is the gap in milliseconds used for GIF frames with a
+// delay of 0 or 1 centiseconds, which browsers also slow down.
+const defaultGIFDelay = 100
+
+// EncodeGIF converts an animated GIF into the commands that upload and play
+// it as image imageID:
+//
+//   - a transmit of the first frame, composed onto a transparent canvas of
+//     the GIF's size, as the root frame, followed by a frame command setting
+//     its gap;
+//   - one frame command per further frame, each based on the previous frame
+//     (BackgroundFrame). A frame following one with no disposal carries only
+//     its own rectangle, placed with Offset and alpha blended. A frame
+//     following one disposed to the background or to the previous state
+//     carries the final pixels of the area that changed, replacing them
+//     (CompositionReplace);
+//   - an AnimationLoop command with the GIF's loop count.
+//
+// Delays are converted from centiseconds to milliseconds. Pixel data is
+// RGBA and ZLIB compressed. The transmit does not display the image; place
+// it with NewPut.
+func EncodeGIF(g *gif.GIF, imageID uint32) ([]*Command, error) {
+	if len(g.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		for _, frame := range g.Image {
+			screen = screen.Union(frame.Bounds())
+		}
+	}
+
+	e := newCanvasEncoder(imageID, screen)
+	for k, frame := range g.Image {
+		d := disposeNone
+		if k < len(g.Disposal) {
+			switch g.Disposal[k] {
+			case gif.DisposalBackground:
+				d = disposeBackground
+			case gif.DisposalPrevious:
+				d = disposePrevious
+			}
+		}
+		if err := e.add(frame, frame.Bounds(), draw.Over, d, gifDelay(g, k)); err != nil {
+			return nil, err
+		}
+	}
+	return e.finish(gifLoopCount(g.LoopCount)), nil
+}
+
+// gifDelay returns the gap of frame k in milliseconds.
+func gifDelay(g *gif.GIF, k int) uint32 {
+	if k >= len(g.Delay) || g.Delay[k] <= 1 {
+		return defaultGIFDelay
+	}
+	return uint32(g.Delay[k]) * 10
+}
+
+// gifLoopCount maps the GIF loop count (0 = forever, -1 = play once, n =
+// repeat n times) to the protocol's (1 = forever, n = play n-1 times).
+func gifLoopCount(loops int) uint32 {
+	switch {
+	case loops == 0:
+		return 1
+	case loops < 0:
+		return 2
+	}
+	return uint32(loops) + 2
+}
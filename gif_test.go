@@ -0,0 +1,165 @@
+package kgp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"testing"
+)
+
+var (
+	gifTransparent = color.RGBA{}
+	gifRed         = color.RGBA{255, 0, 0, 255}
+	gifGreen       = color.RGBA{0, 255, 0, 255}
+	gifBlue        = color.RGBA{0, 0, 255, 255}
+	gifPalette     = color.Palette{gifTransparent, gifRed, gifGreen, gifBlue}
+)
+
+func gifFrame(r image.Rectangle, c color.Color) *image.Paletted {
+	img := image.NewPaletted(r, gifPalette)
+	idx := uint8(gifPalette.Index(c))
+	for i := range img.Pix {
+		img.Pix[i] = idx
+	}
+	return img
+}
+
+// inflatePixels decompresses a payload into RGBA colours
+func inflatePixels(t *testing.T, cmd *Command) []color.RGBA {
+	t.Helper()
+	zr, err := zlib.NewReader(bytes.NewReader(cmd.payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels := make([]color.RGBA, len(data)/4)
+	for i := range pixels {
+		pixels[i] = color.RGBA{data[4*i], data[4*i+1], data[4*i+2], data[4*i+3]}
+	}
+	return pixels
+}
+
+func checkKeys(t *testing.T, name string, cmd *Command, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		got, ok := cmd.Key(k)
+		if v == "" {
+			if ok {
+				t.Errorf("%s: unexpected key %s=%s", name, k, got)
+			}
+			continue
+		}
+		if got != v {
+			t.Errorf("%s: key %s = %q, want %q", name, k, got, v)
+		}
+	}
+}
+
+// TestEncodeGIF tests converting frames, offsets, disposal and gaps
+func TestEncodeGIF(t *testing.T) {
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			gifFrame(image.Rect(0, 0, 4, 4), gifRed),
+			gifFrame(image.Rect(1, 1, 3, 3), gifGreen),
+			gifFrame(image.Rect(0, 0, 1, 1), gifBlue),
+			gifFrame(image.Rect(2, 2, 4, 4), gifGreen),
+		},
+		Delay:    []int{5, 0, 10, 7},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+
+	cmds, err := EncodeGIF(g, 42)
+	if err != nil {
+		t.Fatalf("EncodeGIF error: %v", err)
+	}
+	if len(cmds) != 6 {
+		t.Fatalf("expected 6 commands, got %d", len(cmds))
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Validate(); err != nil {
+			t.Errorf("command %d invalid: %v", i, err)
+		}
+	}
+
+	checkKeys(t, "root", cmds[0], map[string]string{"a": "t", "i": "42", "f": "32", "s": "4", "v": "4", "o": "z"})
+	for i, p := range inflatePixels(t, cmds[0]) {
+		if p != gifRed {
+			t.Fatalf("root pixel %d = %v", i, p)
+		}
+	}
+	checkKeys(t, "root gap", cmds[1], map[string]string{"a": "f", "r": "1", "z": "50"})
+
+	// No disposal before: only the frame's rectangle, blended
+	checkKeys(t, "frame 2", cmds[2], map[string]string{"a": "f", "c": "1", "z": "100", "x": "1", "y": "1", "s": "2", "v": "2", "X": ""})
+	for _, p := range inflatePixels(t, cmds[2]) {
+		if p != gifGreen {
+			t.Fatalf("frame 2 pixel = %v", p)
+		}
+	}
+
+	// Background disposal before: the cleared area and the frame, replaced
+	checkKeys(t, "frame 3", cmds[3], map[string]string{"c": "2", "z": "100", "x": "0", "y": "0", "s": "3", "v": "3", "X": "1"})
+	T, R, B := gifTransparent, gifRed, gifBlue
+	want := []color.RGBA{
+		B, R, R,
+		R, T, T,
+		R, T, T,
+	}
+	if got := inflatePixels(t, cmds[3]); !equalPixels(got, want) {
+		t.Errorf("frame 3 pixels = %v, want %v", got, want)
+	}
+
+	// Previous disposal before: the canvas before frame 3 plus frame 4
+	checkKeys(t, "frame 4", cmds[4], map[string]string{"c": "3", "z": "70", "x": "0", "y": "0", "s": "4", "v": "4", "X": "1"})
+	G := gifGreen
+	want = []color.RGBA{
+		R, R, R, R,
+		R, T, T, R,
+		R, T, G, G,
+		R, R, G, G,
+	}
+	if got := inflatePixels(t, cmds[4]); !equalPixels(got, want) {
+		t.Errorf("frame 4 pixels = %v, want %v", got, want)
+	}
+
+	checkKeys(t, "animate", cmds[5], map[string]string{"a": "a", "i": "42", "s": "3", "v": "1"})
+}
+
+func equalPixels(a, b []color.RGBA) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestEncodeGIF_Empty tests rejection of a GIF without frames
+func TestEncodeGIF_Empty(t *testing.T) {
+	if _, err := EncodeGIF(&gif.GIF{}, 1); err == nil {
+		t.Error("expected error for empty GIF")
+	}
+}
+
+// TestGIFLoopCount tests mapping GIF loop counts to the protocol
+func TestGIFLoopCount(t *testing.T) {
+	tests := []struct {
+		loops int
+		want  uint32
+	}{{0, 1}, {-1, 2}, {1, 3}, {5, 7}}
+	for _, tt := range tests {
+		if got := gifLoopCount(tt.loops); got != tt.want {
+			t.Errorf("gifLoopCount(%d) = %d, want %d", tt.loops, got, tt.want)
+		}
+	}
+}
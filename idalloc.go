@@ -0,0 +1,156 @@
+package kgp
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+)
+
+// ErrIDsExhausted indicates an IDAllocator has no free IDs left.
+var ErrIDsExhausted = errors.New("no free IDs left")
+
+const (
+	// MaxImageID is the largest image ID the protocol allows
+	MaxImageID uint32 = 1<<32 - 1
+	// MaxPlaceholderImageID is the largest image ID a Unicode placeholder
+	// encodes in its 24-bit colour alone, without a third diacritic
+	MaxPlaceholderImageID uint32 = 1<<24 - 1
+	// MaxPlacementID is the largest placement ID a Unicode placeholder can
+	// encode in its underline colour
+	MaxPlacementID uint32 = 1<<24 - 1
+	// DefaultIDBlockSize is the number of image IDs reserved by NewIDAllocator
+	DefaultIDBlockSize uint32 = 1 << 16
+)
+
+// IDAllocator hands out image IDs from a block of consecutive IDs at a
+// random position, so independent allocators in the same process or in
+// other programs sharing the terminal are unlikely to collide, and
+// placement IDs per image. Released IDs are reused, oldest first. It is
+// safe for concurrent use.
+type IDAllocator struct {
+	mu         sync.Mutex
+	first      uint32
+	last       uint32
+	next       uint32
+	free       []uint32
+	used       map[uint32]bool
+	placements map[uint32]*placementIDs
+}
+
+// placementIDs tracks the placement IDs of one image.
+type placementIDs struct {
+	next uint32
+	free []uint32
+	used map[uint32]bool
+}
+
+// NewIDAllocator creates an allocator for DefaultIDBlockSize image IDs no
+// larger than MaxPlaceholderImageID, so they can be used with Unicode
+// placeholders in 24-bit colour mode.
+func NewIDAllocator() *IDAllocator {
+	return NewIDAllocatorRange(MaxPlaceholderImageID, DefaultIDBlockSize)
+}
+
+// NewIDAllocatorRange creates an allocator for blockSize image IDs placed at
+// a random position within [1, maxID]. Use a maxID of 255 for Unicode
+// placeholders in 256-colour mode, or MaxImageID for the full ID space.
+// blockSize is reduced to maxID if larger; a maxID of 0 is treated as 1.
+func NewIDAllocatorRange(maxID, blockSize uint32) *IDAllocator {
+	maxID = max(maxID, 1)
+	blockSize = min(max(blockSize, 1), maxID)
+	// Choose first in [1, maxID-blockSize+1]
+	first := uint32(1 + rand.Uint64N(uint64(maxID-blockSize)+1))
+	return &IDAllocator{
+		first:      first,
+		last:       first + blockSize - 1,
+		next:       first,
+		used:       make(map[uint32]bool),
+		placements: make(map[uint32]*placementIDs),
+	}
+}
+
+// Range returns the first and last image ID the allocator can hand out.
+func (a *IDAllocator) Range() (first, last uint32) {
+	return a.first, a.last
+}
+
+// Allocate returns an unused image ID, or ErrIDsExhausted.
+func (a *IDAllocator) Allocate() (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var id uint32
+	switch {
+	case len(a.free) > 0:
+		id = a.free[0]
+		a.free = a.free[1:]
+	case a.next != 0 && a.next <= a.last:
+		id = a.next
+		// Wraps to 0 after MaxImageID, which ends sequential allocation
+		a.next++
+	default:
+		return 0, ErrIDsExhausted
+	}
+	a.used[id] = true
+	return id, nil
+}
+
+// Release returns an image ID and all its placement IDs for reuse. IDs that
+// are not allocated are ignored.
+func (a *IDAllocator) Release(id uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.used[id] {
+		return
+	}
+	delete(a.used, id)
+	delete(a.placements, id)
+	a.free = append(a.free, id)
+}
+
+// AllocatePlacement returns an unused placement ID for the image, no larger
+// than MaxPlacementID, or ErrIDsExhausted. Placement IDs start at 1 for
+// every image.
+func (a *IDAllocator) AllocatePlacement(imageID uint32) (uint32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p := a.placements[imageID]
+	if p == nil {
+		p = &placementIDs{next: 1, used: make(map[uint32]bool)}
+		a.placements[imageID] = p
+	}
+
+	var id uint32
+	switch {
+	case len(p.free) > 0:
+		id = p.free[0]
+		p.free = p.free[1:]
+	case p.next <= MaxPlacementID:
+		id = p.next
+		p.next++
+	default:
+		return 0, ErrIDsExhausted
+	}
+	p.used[id] = true
+	return id, nil
+}
+
+// ReleasePlacement returns a placement ID of the image for reuse. IDs that
+// are not allocated are ignored.
+func (a *IDAllocator) ReleasePlacement(imageID, placementID uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.placements[imageID]
+	if p == nil || !p.used[placementID] {
+		return
+	}
+	delete(p.used, placementID)
+	p.free = append(p.free, placementID)
+}
+
+// DeleteAll returns a command deleting every image in the allocator's range
+// and freeing its data (DeleteByIDRangeFree).
+func (a *IDAllocator) DeleteAll() *Command {
+	return NewDelete(DeleteByIDRangeFree).IDRange(int(a.first), int(a.last)).Build()
+}
@@ -0,0 +1,160 @@
+package kgp
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestNewIDAllocator tests the default ID range
+func TestNewIDAllocator(t *testing.T) {
+	a := NewIDAllocator()
+	first, last := a.Range()
+	if first == 0 || last > MaxPlaceholderImageID || last-first+1 != DefaultIDBlockSize {
+		t.Errorf("unexpected range %d-%d", first, last)
+	}
+
+	id, err := a.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+	if id != first {
+		t.Errorf("first ID = %d, want %d", id, first)
+	}
+}
+
+// TestIDAllocator_RandomRange tests that allocators get different ranges
+func TestIDAllocator_RandomRange(t *testing.T) {
+	seen := make(map[uint32]bool)
+	for range 10 {
+		first, _ := NewIDAllocator().Range()
+		seen[first] = true
+	}
+	if len(seen) < 2 {
+		t.Error("ranges are not randomised")
+	}
+}
+
+// TestIDAllocator_Exhaustion tests allocating and reusing every ID of a small range
+func TestIDAllocator_Exhaustion(t *testing.T) {
+	a := NewIDAllocatorRange(255, 1000)
+	first, last := a.Range()
+	if first != 1 || last != 255 {
+		t.Fatalf("range = %d-%d, want 1-255", first, last)
+	}
+
+	for i := uint32(1); i <= 255; i++ {
+		id, err := a.Allocate()
+		if err != nil || id != i {
+			t.Fatalf("Allocate() = %d, %v; want %d", id, err, i)
+		}
+	}
+	if _, err := a.Allocate(); !errors.Is(err, ErrIDsExhausted) {
+		t.Fatalf("expected ErrIDsExhausted, got %v", err)
+	}
+
+	a.Release(7)
+	a.Release(3)
+	a.Release(7)   // double release is ignored
+	a.Release(999) // never allocated
+	for _, want := range []uint32{7, 3} {
+		if id, err := a.Allocate(); err != nil || id != want {
+			t.Errorf("Allocate() = %d, %v; want %d", id, err, want)
+		}
+	}
+	if _, err := a.Allocate(); !errors.Is(err, ErrIDsExhausted) {
+		t.Errorf("expected ErrIDsExhausted, got %v", err)
+	}
+}
+
+// TestIDAllocator_FullRange tests a block ending at MaxImageID
+func TestIDAllocator_FullRange(t *testing.T) {
+	a := NewIDAllocatorRange(MaxImageID, 1)
+	a.first, a.last, a.next = MaxImageID, MaxImageID, MaxImageID
+
+	if id, err := a.Allocate(); err != nil || id != MaxImageID {
+		t.Fatalf("Allocate() = %d, %v", id, err)
+	}
+	if _, err := a.Allocate(); !errors.Is(err, ErrIDsExhausted) {
+		t.Errorf("expected ErrIDsExhausted, got %v", err)
+	}
+}
+
+// TestIDAllocator_Placements tests per-image placement IDs
+func TestIDAllocator_Placements(t *testing.T) {
+	a := NewIDAllocator()
+	img1, _ := a.Allocate()
+	img2, _ := a.Allocate()
+
+	for _, tt := range []struct {
+		image uint32
+		want  uint32
+	}{{img1, 1}, {img1, 2}, {img2, 1}, {img1, 3}} {
+		if id, err := a.AllocatePlacement(tt.image); err != nil || id != tt.want {
+			t.Errorf("AllocatePlacement(%d) = %d, %v; want %d", tt.image, id, err, tt.want)
+		}
+	}
+
+	a.ReleasePlacement(img1, 2)
+	if id, _ := a.AllocatePlacement(img1); id != 2 {
+		t.Errorf("released placement ID not reused, got %d", id)
+	}
+
+	// Releasing the image releases its placements
+	a.Release(img1)
+	img, _ := a.Allocate()
+	if img != img1 {
+		t.Fatalf("image ID not reused")
+	}
+	if id, _ := a.AllocatePlacement(img); id != 1 {
+		t.Errorf("placement IDs not reset, got %d", id)
+	}
+}
+
+// TestIDAllocator_DeleteAll tests the range delete command
+func TestIDAllocator_DeleteAll(t *testing.T) {
+	a := NewIDAllocatorRange(1000, 100)
+	first, last := a.Range()
+	cmd := a.DeleteAll()
+
+	if cmd.controlData["d"] != string(DeleteByIDRangeFree) {
+		t.Errorf("d = %q", cmd.controlData["d"])
+	}
+	if cmd.controlData["x"] != strconv.FormatUint(uint64(first), 10) || cmd.controlData["y"] != strconv.FormatUint(uint64(last), 10) {
+		t.Errorf("range %s-%s, want %d-%d", cmd.controlData["x"], cmd.controlData["y"], first, last)
+	}
+	if err := cmd.Validate(); err != nil {
+		t.Errorf("Validate error: %v", err)
+	}
+}
+
+// TestIDAllocator_Concurrent tests concurrent allocation
+func TestIDAllocator_Concurrent(t *testing.T) {
+	a := NewIDAllocator()
+	var mu sync.Mutex
+	seen := make(map[uint32]bool)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				id, err := a.Allocate()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				a.AllocatePlacement(id)
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("duplicate ID %d", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -29,9 +29,13 @@ package kgp
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // Action represents the action type for a graphics command.
@@ -213,52 +217,256 @@ func (c *Command) SetPayload(data []byte) *Command {
 	return c
 }
 
-// Encode generates the complete escape sequence for this command.
-func (c *Command) Encode() string {
-	var sb strings.Builder
+// clone returns a copy of the command with its own control data. The payload
+// is shared.
+func (c *Command) clone() *Command {
+	cd := make(map[string]string, len(c.controlData)+1)
+	for k, v := range c.controlData {
+		cd[k] = v
+	}
+	return &Command{controlData: cd, payload: c.payload}
+}
 
-	// Start APC sequence: ESC_G
-	sb.WriteString("\x1b_G")
+// Action returns the action of the command. Commands without an a key
+// default to ActionTransmit, as in the protocol.
+func (c *Command) Action() Action {
+	if a, ok := c.controlData["a"]; ok {
+		return Action(a)
+	}
+	return ActionTransmit
+}
 
-	// Write control data as comma-separated key=value pairs
-	first := true
-	for k, v := range c.controlData {
-		if !first {
-			sb.WriteString(",")
+// Key returns the value of a control data key and whether it is set.
+func (c *Command) Key(key string) (string, bool) {
+	v, ok := c.controlData[key]
+	return v, ok
+}
+
+// Payload returns the raw (not base64-encoded) payload data.
+func (c *Command) Payload() []byte {
+	return c.payload
+}
+
+// controlKeyOrder is the canonical order in which control data keys are
+// encoded. The action always comes first, followed by the remaining keys in
+// the order the protocol specification documents them. Keys that are not
+// listed here are encoded last, in lexical order.
+var controlKeyOrder = [...]string{
+	"a", "q",
+	// Transmission keys
+	"f", "t", "s", "v", "S", "O", "i", "I", "p", "o",
+	// Display keys
+	"x", "y", "w", "h", "X", "Y", "c", "r", "C", "U", "z", "P", "Q", "H", "V",
+	// Deletion keys
+	"d",
+}
+
+// ErrInvalidChunkSize indicates a chunk size that is not positive, exceeds
+// MaxChunkSize, or is not divisible by 4.
+var ErrInvalidChunkSize = errors.New("maxChunkSize must be positive, ≤4096 and divisible by 4")
+
+// MaxChunkSize is the maximum number of base64 bytes a single chunk of a
+// chunked transmission may carry.
+const MaxChunkSize = 4096
+
+// maxPooledEncodeBuffer bounds the capacity of buffers kept for reuse by WriteTo.
+const maxPooledEncodeBuffer = 64 * 1024
+
+var encodeBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+func isCanonicalKey(key string) bool {
+	for _, k := range controlKeyOrder {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// appendControlData appends the comma-separated key=value pairs of the
+// command in canonical order.
+func (c *Command) appendControlData(dst []byte) []byte {
+	n := 0
+	for _, k := range controlKeyOrder {
+		v, ok := c.controlData[k]
+		if !ok {
+			continue
+		}
+		if n > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendKeyValue(dst, k, v)
+		n++
+	}
+
+	if n == len(c.controlData) {
+		return dst
+	}
+
+	// Custom keys set through SetKey are rare, so only pay for sorting them
+	// when they are present.
+	extra := make([]string, 0, len(c.controlData)-n)
+	for k := range c.controlData {
+		if !isCanonicalKey(k) {
+			extra = append(extra, k)
 		}
-		first = false
-		sb.WriteString(k)
-		sb.WriteString("=")
-		sb.WriteString(v)
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		if n > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendKeyValue(dst, k, c.controlData[k])
+		n++
 	}
 
+	return dst
+}
+
+func appendKeyValue(dst []byte, key, value string) []byte {
+	dst = append(dst, key...)
+	dst = append(dst, '=')
+	return append(dst, value...)
+}
+
+// controlDataLen returns the encoded length of the control data.
+func (c *Command) controlDataLen() int {
+	n := 0
+	for k, v := range c.controlData {
+		n += len(k) + len(v) + 2
+	}
+	return n
+}
+
+// encodedLen returns the exact length of the sequence produced by AppendEncode.
+func (c *Command) encodedLen() int {
+	n := len("\x1b_G") + len("\x1b\\") + c.controlDataLen()
+	if len(c.controlData) > 0 {
+		n-- // no separator after the last pair
+	}
+	if len(c.payload) > 0 {
+		n += 1 + base64.StdEncoding.EncodedLen(len(c.payload))
+	}
+	return n
+}
+
+// AppendEncode appends the complete escape sequence for this command to dst
+// and returns the extended buffer. Control data keys are written in a
+// canonical order, so the output is byte-for-byte reproducible. The payload
+// is base64-encoded directly into dst; when dst has sufficient capacity no
+// allocations are made.
+func (c *Command) AppendEncode(dst []byte) []byte {
+	// Start APC sequence: ESC_G
+	dst = append(dst, "\x1b_G"...)
+
+	// Write control data as comma-separated key=value pairs
+	dst = c.appendControlData(dst)
+
 	// Add payload if present
 	if len(c.payload) > 0 {
-		sb.WriteString(";")
-		sb.WriteString(base64.StdEncoding.EncodeToString(c.payload))
+		dst = append(dst, ';')
+		dst = base64.StdEncoding.AppendEncode(dst, c.payload)
 	}
 
 	// End APC sequence: ESC\
-	sb.WriteString("\x1b\\")
+	return append(dst, "\x1b\\"...)
+}
+
+// Encode generates the complete escape sequence for this command.
+func (c *Command) Encode() string {
+	return string(c.AppendEncode(make([]byte, 0, c.encodedLen())))
+}
+
+// WriteTo writes the complete escape sequence for this command to w.
+// It implements io.WriterTo and reuses internal buffers between calls.
+func (c *Command) WriteTo(w io.Writer) (int64, error) {
+	bufp := encodeBufPool.Get().(*[]byte)
+	buf := c.AppendEncode((*bufp)[:0])
+
+	n, err := w.Write(buf)
 
-	return sb.String()
+	if cap(buf) <= maxPooledEncodeBuffer {
+		*bufp = buf[:0]
+		encodeBufPool.Put(bufp)
+	}
+
+	return int64(n), err
+}
+
+// continuationKeys are the control data keys repeated on every chunk of a
+// chunked transmission. The terminal honours q on each chunk, and the image
+// ID lets it tell interleaved uploads apart.
+var continuationKeys = [...]string{"q", "i"}
+
+// appendChunkHeader appends the APC introducer and control data for one chunk
+// of a chunked transmission. The first chunk carries all control data, later
+// chunks carry only the continuation keys and the m key, plus a=f for frame
+// data, which the terminal would otherwise load as a transmission. A chunk
+// that is both first and last is a plain command without the m key.
+func (c *Command) appendChunkHeader(dst []byte, first, last bool) []byte {
+	dst = append(dst, "\x1b_G"...)
+
+	if first {
+		// First chunk: include all control data
+		dst = c.appendControlData(dst)
+
+		// Add m=1 if not last chunk
+		if !last {
+			dst = append(dst, ",m=1"...)
+		}
+		return dst
+	}
+
+	// Subsequent chunks: continuation keys and the m key
+	if c.Action() == ActionFrame {
+		dst = append(dst, "a=f,"...)
+	}
+	for _, k := range continuationKeys {
+		if v, ok := c.controlData[k]; ok {
+			dst = appendKeyValue(dst, k, v)
+			dst = append(dst, ',')
+		}
+	}
+	if !last {
+		return append(dst, "m=1"...)
+	}
+	return append(dst, "m=0"...)
 }
 
 // EncodeChunked generates multiple escape sequences for chunked transmission.
 // The payload is split into chunks of maxChunkSize (must be ≤4096 and divisible by 4).
+// It panics on an invalid chunk size; see TryEncodeChunked.
 func (c *Command) EncodeChunked(maxChunkSize int) []string {
-	if maxChunkSize > 4096 || maxChunkSize%4 != 0 {
-		panic("maxChunkSize must be ≤4096 and divisible by 4")
+	chunks, err := c.TryEncodeChunked(maxChunkSize)
+	if err != nil {
+		panic(err.Error())
+	}
+	return chunks
+}
+
+// TryEncodeChunked generates multiple escape sequences for chunked transmission
+// and returns ErrInvalidChunkSize instead of panicking on an invalid chunk size.
+// Every chunk repeats the q and i keys so response suppression applies to the
+// whole transmission.
+func (c *Command) TryEncodeChunked(maxChunkSize int) ([]string, error) {
+	if maxChunkSize <= 0 || maxChunkSize > MaxChunkSize || maxChunkSize%4 != 0 {
+		return nil, ErrInvalidChunkSize
 	}
 
 	if len(c.payload) == 0 {
-		return []string{c.Encode()}
+		return []string{c.Encode()}, nil
 	}
 
 	// Base64 encode the entire payload first
-	encoded := base64.StdEncoding.EncodeToString(c.payload)
+	encoded := base64.StdEncoding.AppendEncode(nil, c.payload)
 
 	var chunks []string
+	buf := make([]byte, 0, c.controlDataLen()+maxChunkSize+16)
 
 	for i := 0; i < len(encoded); i += maxChunkSize {
 		end := i + maxChunkSize
@@ -267,45 +475,15 @@ func (c *Command) EncodeChunked(maxChunkSize int) []string {
 			end = len(encoded)
 		}
 
-		chunk := encoded[i:end]
-
-		var sb strings.Builder
-		sb.WriteString("\x1b_G")
-
-		if i == 0 {
-			// First chunk: include all control data
-			first := true
-			for k, v := range c.controlData {
-				if !first {
-					sb.WriteString(",")
-				}
-				first = false
-				sb.WriteString(k)
-				sb.WriteString("=")
-				sb.WriteString(v)
-			}
+		buf = c.appendChunkHeader(buf[:0], i == 0, isLast)
+		buf = append(buf, ';')
+		buf = append(buf, encoded[i:end]...)
+		buf = append(buf, "\x1b\\"...)
 
-			// Add m=1 if not last chunk
-			if !isLast {
-				sb.WriteString(",m=1")
-			}
-		} else {
-			// Subsequent chunks: only m key
-			if !isLast {
-				sb.WriteString("m=1")
-			} else {
-				sb.WriteString("m=0")
-			}
-		}
-
-		sb.WriteString(";")
-		sb.WriteString(chunk)
-		sb.WriteString("\x1b\\")
-
-		chunks = append(chunks, sb.String())
+		chunks = append(chunks, string(buf))
 	}
 
-	return chunks
+	return chunks, nil
 }
 
 // Response represents a terminal response to a graphics command.
@@ -313,20 +491,22 @@ type Response struct {
 	ImageID     uint32
 	ImageNumber uint32
 	PlacementID uint32
+	FrameNumber uint32            // Frame number echoed for frame operations (r key)
+	Keys        map[string]string // Other echoed control data keys, nil if none
 	Success     bool
 	ErrorCode   string
 	Message     string
 }
 
 // ParseResponse parses a terminal response.
-// Format: ESC_Gi=<id>[,I=<num>][,p=<pid>];[OK|ERROR_CODE:message]ESC\
+// Format: ESC_Gi=<id>[,I=<num>][,p=<pid>][,r=<frame>][,<key>=<value>...];[OK|ERROR_CODE:message]ESC\
 func ParseResponse(response string) (*Response, error) {
 	return parseResponse(response, false)
 }
 
 // ParseResponseStrict parses a terminal response with strict format validation.
-// Strict mode requires APC markers, valid key=value control pairs, and either
-// "OK" status or "ERROR_CODE:message" status.
+// Strict mode requires APC markers, valid key=value control pairs without
+// duplicate keys, and either "OK" status or "ERROR_CODE:message" status.
 func ParseResponseStrict(response string) (*Response, error) {
 	return parseResponse(response, true)
 }
@@ -354,6 +534,7 @@ func parseResponse(response string, strict bool) (*Response, error) {
 	resp := &Response{}
 
 	// Parse control data
+	seen := make(map[string]bool)
 	for _, pair := range strings.Split(controlData, ",") {
 		if pair == "" {
 			continue
@@ -366,35 +547,39 @@ func parseResponse(response string, strict bool) (*Response, error) {
 			continue
 		}
 
+		if strict {
+			if seen[kv[0]] {
+				return nil, fmt.Errorf("duplicate control data key: %q", kv[0])
+			}
+			seen[kv[0]] = true
+		}
+
+		var field *uint32
 		switch kv[0] {
 		case "i":
-			val, err := strconv.ParseUint(kv[1], 10, 32)
-			if err != nil {
-				if strict {
-					return nil, fmt.Errorf("invalid i value: %w", err)
-				}
-				continue
-			}
-			resp.ImageID = uint32(val)
+			field = &resp.ImageID
 		case "I":
-			val, err := strconv.ParseUint(kv[1], 10, 32)
-			if err != nil {
-				if strict {
-					return nil, fmt.Errorf("invalid I value: %w", err)
-				}
-				continue
-			}
-			resp.ImageNumber = uint32(val)
+			field = &resp.ImageNumber
 		case "p":
-			val, err := strconv.ParseUint(kv[1], 10, 32)
-			if err != nil {
-				if strict {
-					return nil, fmt.Errorf("invalid p value: %w", err)
-				}
-				continue
+			field = &resp.PlacementID
+		case "r":
+			field = &resp.FrameNumber
+		default:
+			if resp.Keys == nil {
+				resp.Keys = make(map[string]string)
 			}
-			resp.PlacementID = uint32(val)
+			resp.Keys[kv[0]] = kv[1]
+			continue
+		}
+
+		val, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid %s value: %w", kv[0], err)
+			}
+			continue
 		}
+		*field = uint32(val)
 	}
 
 	// Parse status
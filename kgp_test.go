@@ -1,6 +1,7 @@
 package kgp
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -91,6 +92,78 @@ func TestCommandEncodeWithPayload(t *testing.T) {
 	}
 }
 
+// TestCommandEncodeDeterministic tests that control data is encoded in canonical order
+func TestCommandEncodeDeterministic(t *testing.T) {
+	cmd := NewTransmitDisplay().
+		ResponseSuppression(ResponseErrorsOnly).
+		ZIndex(-1).
+		DisplaySize(20, 15).
+		ImageID(42).
+		Format(FormatPNG).
+		TransmitDirect([]byte("test")).
+		Build()
+	cmd.SetKey("custom", "1")
+	cmd.SetKey("b", "2")
+
+	want := "\x1b_Ga=T,q=1,f=100,t=d,i=42,c=20,r=15,z=-1,b=2,custom=1;dGVzdA==\x1b\\"
+	for i := 0; i < 20; i++ {
+		if got := cmd.Encode(); got != want {
+			t.Fatalf("Encode() = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestCommandAppendEncode tests appending the encoding to an existing buffer
+func TestCommandAppendEncode(t *testing.T) {
+	cmd := NewPut(10).PlacementID(2).Build()
+	cmd.SetPayload([]byte("test"))
+
+	got := cmd.AppendEncode([]byte("prefix"))
+	want := "prefix" + cmd.Encode()
+	if string(got) != want {
+		t.Errorf("AppendEncode() = %q, want %q", got, want)
+	}
+	if len(cmd.Encode()) != cmd.encodedLen() {
+		t.Errorf("encodedLen() = %d, want %d", cmd.encodedLen(), len(cmd.Encode()))
+	}
+}
+
+// TestCommandAppendEncodeAllocs tests that encoding into a sized buffer does not allocate
+func TestCommandAppendEncodeAllocs(t *testing.T) {
+	cmd := NewPut(10).
+		PlacementID(2).
+		DisplaySize(20, 15).
+		SourceRect(0, 0, 100, 100).
+		ZIndex(3).
+		Build()
+	cmd.SetPayload(make([]byte, 300))
+	buf := make([]byte, 0, 1024)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = cmd.AppendEncode(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("AppendEncode allocated %v times per run, want 0", allocs)
+	}
+}
+
+// TestCommandWriteTo tests writing the encoding to an io.Writer
+func TestCommandWriteTo(t *testing.T) {
+	cmd := NewDelete(DeleteByImageID).ImageID(7).Build()
+
+	var sb strings.Builder
+	n, err := cmd.WriteTo(&sb)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if sb.String() != cmd.Encode() {
+		t.Errorf("WriteTo wrote %q, want %q", sb.String(), cmd.Encode())
+	}
+	if n != int64(sb.Len()) {
+		t.Errorf("WriteTo returned %d, want %d", n, sb.Len())
+	}
+}
+
 // TestCommandEncodeChunked tests chunked encoding
 func TestCommandEncodeChunked(t *testing.T) {
 	cmd := NewCommand(ActionTransmit)
@@ -119,6 +192,79 @@ func TestCommandEncodeChunked(t *testing.T) {
 	}
 }
 
+// TestCommandEncodeChunkedDeterministic tests that the first chunk uses canonical key order
+func TestCommandEncodeChunkedDeterministic(t *testing.T) {
+	cmd := NewTransmit().ImageID(3).Format(FormatRGB).Dimensions(2, 2).Build()
+	cmd.SetPayload(make([]byte, 12))
+
+	chunks := cmd.EncodeChunked(8)
+	want := "\x1b_Ga=t,f=24,s=2,v=2,i=3,m=1;AAAAAAAA\x1b\\"
+	if chunks[0] != want {
+		t.Errorf("first chunk = %q, want %q", chunks[0], want)
+	}
+	if chunks[1] != "\x1b_Gi=3,m=0;AAAAAAAA\x1b\\" {
+		t.Errorf("last chunk = %q", chunks[1])
+	}
+}
+
+// TestCommandTryEncodeChunked tests that continuation chunks carry the q and i keys
+func TestCommandTryEncodeChunked(t *testing.T) {
+	cmd := NewTransmit().
+		ImageID(42).
+		Format(FormatPNG).
+		ResponseSuppression(ResponseErrorsOnly).
+		TransmitDirect(make([]byte, 30)).
+		Build()
+
+	chunks, err := cmd.TryEncodeChunked(16)
+	if err != nil {
+		t.Fatalf("TryEncodeChunked error: %v", err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[1], "\x1b_Gq=1,i=42,m=1;") {
+		t.Errorf("unexpected middle chunk: %q", chunks[1])
+	}
+	if !strings.HasPrefix(chunks[2], "\x1b_Gq=1,i=42,m=0;") {
+		t.Errorf("unexpected last chunk: %q", chunks[2])
+	}
+	if strings.Contains(chunks[1], "f=") {
+		t.Error("continuation chunk should not repeat other keys")
+	}
+}
+
+// TestCommandEncodeChunkedFrame tests that continuation chunks of frame data repeat a=f
+func TestCommandEncodeChunkedFrame(t *testing.T) {
+	cmd := NewFrame(5).Format(FormatRGBA).Dimensions(40, 40).FrameData(make([]byte, 6400)).Build()
+	chunks := cmd.EncodeChunked(4096)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[1], "\x1b_Ga=f,i=5,m=1;") {
+		t.Errorf("unexpected middle chunk: %q", chunks[1][:20])
+	}
+	if !strings.HasPrefix(chunks[2], "\x1b_Ga=f,i=5,m=0;") {
+		t.Errorf("unexpected last chunk: %q", chunks[2][:20])
+	}
+}
+
+// TestCommandTryEncodeChunkedInvalid tests chunk size validation without panic
+func TestCommandTryEncodeChunkedInvalid(t *testing.T) {
+	cmd := NewCommand(ActionTransmit)
+	cmd.SetPayload([]byte("test"))
+
+	for _, size := range []int{0, -4, 5000, 102} {
+		chunks, err := cmd.TryEncodeChunked(size)
+		if !errors.Is(err, ErrInvalidChunkSize) {
+			t.Errorf("TryEncodeChunked(%d) error = %v, want ErrInvalidChunkSize", size, err)
+		}
+		if chunks != nil {
+			t.Errorf("TryEncodeChunked(%d) should return nil chunks on error", size)
+		}
+	}
+}
+
 // TestCommandEncodeChunkedPanic tests that invalid chunk size panics
 func TestCommandEncodeChunkedPanic(t *testing.T) {
 	defer func() {
@@ -258,6 +404,53 @@ func TestParseResponseStrictInvalidStatus(t *testing.T) {
 	}
 }
 
+// TestParseResponseFrameNumber tests parsing the echoed frame number
+func TestParseResponseFrameNumber(t *testing.T) {
+	resp, err := ParseResponseStrict("\x1b_Gi=20,r=3;OK\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseResponseStrict error: %v", err)
+	}
+	if resp.ImageID != 20 || resp.FrameNumber != 3 {
+		t.Errorf("ImageID = %d, FrameNumber = %d, want 20, 3", resp.ImageID, resp.FrameNumber)
+	}
+	if resp.Keys != nil {
+		t.Errorf("Keys = %v, want nil", resp.Keys)
+	}
+}
+
+// TestParseResponseKeys tests that unknown echoed keys are preserved
+func TestParseResponseKeys(t *testing.T) {
+	resp, err := ParseResponse("\x1b_Gi=1,x=5,foo=bar;OK\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseResponse error: %v", err)
+	}
+	if resp.Keys["x"] != "5" || resp.Keys["foo"] != "bar" || len(resp.Keys) != 2 {
+		t.Errorf("Keys = %v", resp.Keys)
+	}
+}
+
+// TestParseResponseDuplicateKeys tests duplicate key handling in both modes
+func TestParseResponseDuplicateKeys(t *testing.T) {
+	if _, err := ParseResponseStrict("\x1b_Gi=1,i=2;OK\x1b\\"); err == nil {
+		t.Error("expected strict error for duplicate key")
+	}
+
+	resp, err := ParseResponse("\x1b_Gi=1,i=2;OK\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseResponse error: %v", err)
+	}
+	if resp.ImageID != 2 {
+		t.Errorf("ImageID = %d, want last value 2", resp.ImageID)
+	}
+}
+
+// TestParseResponseStrictInvalidFrameNumber tests strict validation of r values
+func TestParseResponseStrictInvalidFrameNumber(t *testing.T) {
+	if _, err := ParseResponseStrict("\x1b_Gi=1,r=x;OK\x1b\\"); err == nil {
+		t.Error("expected strict error for invalid r value")
+	}
+}
+
 // TestActions tests action constants
 func TestActions(t *testing.T) {
 	actions := map[Action]string{
@@ -0,0 +1,140 @@
+package kgp
+
+import "image"
+
+// FitMode controls how an image is scaled into a box of cells.
+type FitMode int
+
+const (
+	// FitContain scales the image to fit inside the box, preserving its
+	// aspect ratio and aligning it within the unused space
+	FitContain FitMode = iota
+	// FitCover scales the image to fill the box, preserving its aspect ratio
+	// and cropping the parts that do not fit
+	FitCover
+	// FitStretch scales the image to exactly fill the box, ignoring its aspect ratio
+	FitStretch
+	// FitNoUpscale behaves like FitContain for images larger than the box and
+	// displays smaller images at their native size
+	FitNoUpscale
+)
+
+// Alignment positions an image within the free space of its box, or selects
+// the part of the image kept by FitCover.
+type Alignment int
+
+const (
+	// AlignCenter centers the image (default)
+	AlignCenter Alignment = iota
+	// AlignTopLeft aligns the image with the top-left corner
+	AlignTopLeft
+	// AlignBottomRight aligns the image with the bottom-right corner
+	AlignBottomRight
+)
+
+// Layout is the placement of an image within a box of cells, as computed by Fit.
+type Layout struct {
+	// Columns and Rows are the display size in cells. Zero means the key is
+	// omitted and the terminal derives it from the image size.
+	Columns, Rows int
+	// Column and Row are the cell, relative to the top-left of the box, at
+	// which the image starts. Move the cursor there before placing it.
+	Column, Row int
+	// OffsetX and OffsetY are the pixel offset within the starting cell.
+	OffsetX, OffsetY int
+	// Source is the part of the image to display. An empty rectangle
+	// displays the whole image.
+	Source image.Rectangle
+}
+
+// Fit computes the layout of an image of imageWidth x imageHeight pixels in a
+// box of columns x rows cells, using the cell size from g. Sizes are exact to
+// the pixel: FitContain sets only the constrained dimension so the terminal
+// preserves the aspect ratio, and centers the image with CellOffset rather
+// than whole cells. Fit returns a zero Layout if any size is not positive.
+func Fit(mode FitMode, align Alignment, imageWidth, imageHeight, columns, rows int, g Geometry) Layout {
+	if imageWidth <= 0 || imageHeight <= 0 || columns <= 0 || rows <= 0 || !g.hasCellSize() {
+		return Layout{}
+	}
+	boxWidth, boxHeight := g.Pixels(columns, rows)
+
+	switch mode {
+	case FitStretch:
+		return Layout{Columns: columns, Rows: rows}
+
+	case FitCover:
+		l := Layout{Columns: columns, Rows: rows}
+		// Crop the image to the aspect ratio of the box
+		srcWidth, srcHeight := imageWidth, imageHeight
+		if imageWidth*boxHeight > boxWidth*imageHeight {
+			srcWidth = (imageHeight*boxWidth + boxHeight/2) / boxHeight
+		} else {
+			srcHeight = (imageWidth*boxHeight + boxWidth/2) / boxWidth
+		}
+		if srcWidth == imageWidth && srcHeight == imageHeight {
+			return l
+		}
+		x := align.offset(imageWidth - srcWidth)
+		y := align.offset(imageHeight - srcHeight)
+		l.Source = image.Rect(x, y, x+srcWidth, y+srcHeight)
+		return l
+
+	case FitNoUpscale:
+		if imageWidth <= boxWidth && imageHeight <= boxHeight {
+			var l Layout
+			l.place(g, align, boxWidth-imageWidth, boxHeight-imageHeight)
+			return l
+		}
+	}
+
+	var l Layout
+	if imageWidth*boxHeight >= boxWidth*imageHeight {
+		// Limited by width: the terminal computes the rows
+		l.Columns = columns
+		height := (imageHeight*boxWidth + imageWidth/2) / imageWidth
+		l.place(g, align, 0, boxHeight-height)
+	} else {
+		// Limited by height: the terminal computes the columns
+		l.Rows = rows
+		width := (imageWidth*boxHeight + imageHeight/2) / imageHeight
+		l.place(g, align, boxWidth-width, 0)
+	}
+	return l
+}
+
+// place positions the image within the free space of the box.
+func (l *Layout) place(g Geometry, align Alignment, freeWidth, freeHeight int) {
+	l.Column, l.Row, l.OffsetX, l.OffsetY = g.Position(align.offset(freeWidth), align.offset(freeHeight))
+}
+
+// offset returns the share of free space before the image.
+func (a Alignment) offset(free int) int {
+	switch a {
+	case AlignTopLeft:
+		return 0
+	case AlignBottomRight:
+		return free
+	default:
+		return free / 2
+	}
+}
+
+// apply sets the display keys of the layout on cmd.
+func (l Layout) apply(cmd *Command) {
+	if l.Columns > 0 {
+		cmd.SetKeyInt("c", l.Columns)
+	}
+	if l.Rows > 0 {
+		cmd.SetKeyInt("r", l.Rows)
+	}
+	if l.OffsetX > 0 || l.OffsetY > 0 {
+		cmd.SetKeyInt("X", l.OffsetX)
+		cmd.SetKeyInt("Y", l.OffsetY)
+	}
+	if !l.Source.Empty() {
+		cmd.SetKeyInt("x", l.Source.Min.X)
+		cmd.SetKeyInt("y", l.Source.Min.Y)
+		cmd.SetKeyInt("w", l.Source.Dx())
+		cmd.SetKeyInt("h", l.Source.Dy())
+	}
+}
@@ -0,0 +1,92 @@
+package kgp
+
+import (
+	"image"
+	"testing"
+)
+
+// TestFit tests layout computation for each fit mode and alignment
+func TestFit(t *testing.T) {
+	g := Geometry{CellWidth: 10, CellHeight: 20}
+
+	tests := []struct {
+		name          string
+		mode          FitMode
+		align         Alignment
+		width, height int
+		want          Layout
+	}{
+		{"contain wide centered", FitContain, AlignCenter, 200, 100,
+			Layout{Columns: 10, Row: 1, OffsetY: 5}},
+		{"contain wide top-left", FitContain, AlignTopLeft, 200, 100,
+			Layout{Columns: 10}},
+		{"contain wide bottom-right", FitContain, AlignBottomRight, 200, 100,
+			Layout{Columns: 10, Row: 2, OffsetY: 10}},
+		{"contain tall centered", FitContain, AlignCenter, 100, 200,
+			Layout{Rows: 5, Column: 2, OffsetX: 5}},
+		{"contain small image is upscaled", FitContain, AlignTopLeft, 10, 10,
+			Layout{Columns: 10}},
+		{"stretch", FitStretch, AlignCenter, 200, 100,
+			Layout{Columns: 10, Rows: 5}},
+		{"cover wide centered", FitCover, AlignCenter, 200, 100,
+			Layout{Columns: 10, Rows: 5, Source: image.Rect(50, 0, 150, 100)}},
+		{"cover wide top-left", FitCover, AlignTopLeft, 200, 100,
+			Layout{Columns: 10, Rows: 5, Source: image.Rect(0, 0, 100, 100)}},
+		{"cover tall bottom-right", FitCover, AlignBottomRight, 100, 300,
+			Layout{Columns: 10, Rows: 5, Source: image.Rect(0, 200, 100, 300)}},
+		{"cover same aspect", FitCover, AlignCenter, 50, 50,
+			Layout{Columns: 10, Rows: 5}},
+		{"no upscale small image", FitNoUpscale, AlignCenter, 40, 20,
+			Layout{Column: 3, Row: 2}},
+		{"no upscale small image bottom-right", FitNoUpscale, AlignBottomRight, 45, 30,
+			Layout{Column: 5, OffsetX: 5, Row: 3, OffsetY: 10}},
+		{"no upscale large image", FitNoUpscale, AlignCenter, 400, 100,
+			Layout{Columns: 10, Row: 1, OffsetY: 17}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Fit(tt.mode, tt.align, tt.width, tt.height, 10, 5, g)
+			if got != tt.want {
+				t.Errorf("Fit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFit_Invalid tests that invalid sizes yield a zero layout
+func TestFit_Invalid(t *testing.T) {
+	g := Geometry{CellWidth: 10, CellHeight: 20}
+	if l := Fit(FitContain, AlignCenter, 0, 100, 10, 5, g); l != (Layout{}) {
+		t.Errorf("zero image width: %+v", l)
+	}
+	if l := Fit(FitContain, AlignCenter, 100, 100, 0, 5, g); l != (Layout{}) {
+		t.Errorf("zero box: %+v", l)
+	}
+	if l := Fit(FitContain, AlignCenter, 100, 100, 10, 5, Geometry{}); l != (Layout{}) {
+		t.Errorf("unknown cell size: %+v", l)
+	}
+}
+
+// TestLayout_Apply tests applying a layout to put and transmit commands
+func TestLayout_Apply(t *testing.T) {
+	l := Layout{Columns: 10, Rows: 5, OffsetX: 3, OffsetY: 4, Source: image.Rect(50, 0, 150, 100)}
+	want := map[string]string{"c": "10", "r": "5", "X": "3", "Y": "4", "x": "50", "y": "0", "w": "100", "h": "100"}
+
+	put := NewPut(1).Layout(l).Build()
+	transmit := NewTransmitDisplay().Layout(l).Build()
+	for _, cmd := range []*Command{put, transmit} {
+		for k, v := range want {
+			if cmd.controlData[k] != v {
+				t.Errorf("key %s = %q, want %q", k, cmd.controlData[k], v)
+			}
+		}
+	}
+
+	cmd := NewPut(1).Layout(Layout{Columns: 10}).Build()
+	for _, k := range []string{"r", "X", "Y", "x", "y", "w", "h"} {
+		if _, ok := cmd.controlData[k]; ok {
+			t.Errorf("unexpected key %s", k)
+		}
+	}
+}
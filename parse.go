@@ -0,0 +1,58 @@
+package kgp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ParseCommand decodes a graphics command escape sequence back into a Command.
+// The sequence must be a single complete APC sequence of the form
+// ESC_G<control data>[;<base64 payload>]ESC\. Chunked transmissions are not
+// joined; each chunk parses to its own Command including its m key.
+func ParseCommand(s string) (*Command, error) {
+	if !strings.HasPrefix(s, "\x1b_G") || !strings.HasSuffix(s, "\x1b\\") || len(s) < len("\x1b_G\x1b\\") {
+		return nil, fmt.Errorf("invalid command markers")
+	}
+	return parseCommandBody(s[len("\x1b_G") : len(s)-len("\x1b\\")])
+}
+
+// parseCommandBody parses the contents of an APC graphics sequence without
+// its introducer and terminator.
+func parseCommandBody(body string) (*Command, error) {
+	controlData, payload, hasPayload := strings.Cut(body, ";")
+
+	cmd := &Command{controlData: make(map[string]string)}
+
+	if controlData != "" {
+		for _, pair := range strings.Split(controlData, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("invalid control data pair: %q", pair)
+			}
+			if _, dup := cmd.controlData[k]; dup {
+				return nil, fmt.Errorf("duplicate control data key: %q", k)
+			}
+			cmd.controlData[k] = v
+		}
+	}
+
+	if hasPayload && payload != "" {
+		data, err := decodeBase64Payload(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload: %w", err)
+		}
+		cmd.payload = data
+	}
+
+	return cmd, nil
+}
+
+// decodeBase64Payload decodes standard base64, accepting payloads with the
+// trailing padding omitted.
+func decodeBase64Payload(s string) ([]byte, error) {
+	if len(s)%4 != 0 {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
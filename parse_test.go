@@ -0,0 +1,156 @@
+package kgp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestParseCommandRoundTrip tests that builder output parses back to the same command
+func TestParseCommandRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  *Command
+	}{
+		{
+			name: "Transmit",
+			cmd: NewTransmit().
+				ImageID(10).
+				ImageNumber(3).
+				Format(FormatRGBA).
+				Dimensions(2, 1).
+				Compress().
+				TransmitDirect([]byte{1, 2, 3, 4, 5, 6, 7, 8}).
+				ResponseSuppression(ResponseErrorsOnly).
+				Build(),
+		},
+		{
+			name: "TransmitDisplay",
+			cmd: NewTransmitDisplay().
+				Format(FormatPNG).
+				TransmitFile("/tmp/image.png").
+				DisplaySize(20, 15).
+				SourceRect(1, 2, 3, 4).
+				CellOffset(5, 6).
+				ZIndex(-3).
+				CursorMovement(false).
+				Build(),
+		},
+		{
+			name: "Put",
+			cmd: NewPut(10).
+				PlacementID(2).
+				VirtualPlacement().
+				RelativeTo(11, 1, -2, 3).
+				Build(),
+		},
+		{
+			name: "Delete",
+			cmd:  NewDelete(DeleteByIDRangeFree).IDRange(1, 100).Build(),
+		},
+		{
+			name: "Frame",
+			cmd: NewFrame(20).
+				FrameData([]byte("frame")).
+				Gap(100).
+				BackgroundFrame(1).
+				FrameNumber(2).
+				Composition(CompositionReplace).
+				BackgroundColor(CreateRGBAColor(1, 2, 3, 4)).
+				Build(),
+		},
+		{
+			name: "Animate",
+			cmd:  NewAnimate(20).State(AnimationLoop).LoopCount(1).GapOverride(50).Frame(2).Build(),
+		},
+		{
+			name: "Compose",
+			cmd: NewCompose(20).
+				SourceFrame(1).
+				DestFrame(2).
+				SourceRect(0, 0, 10, 10).
+				DestOffset(5, 5).
+				Composition(CompositionBlend).
+				Build(),
+		},
+		{
+			name: "Query",
+			cmd:  QuerySupport(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.cmd.Encode())
+			if err != nil {
+				t.Fatalf("ParseCommand error: %v", err)
+			}
+			if !reflect.DeepEqual(got.controlData, tt.cmd.controlData) {
+				t.Errorf("control data = %v, want %v", got.controlData, tt.cmd.controlData)
+			}
+			if !bytes.Equal(got.Payload(), tt.cmd.Payload()) {
+				t.Errorf("payload = %q, want %q", got.Payload(), tt.cmd.Payload())
+			}
+			if got.Encode() != tt.cmd.Encode() {
+				t.Errorf("re-encoded = %q, want %q", got.Encode(), tt.cmd.Encode())
+			}
+		})
+	}
+}
+
+// TestParseCommandAccessors tests the Action, Key and Payload accessors
+func TestParseCommandAccessors(t *testing.T) {
+	cmd, err := ParseCommand("\x1b_Ga=p,i=10,p=2;dGVzdA==\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseCommand error: %v", err)
+	}
+	if cmd.Action() != ActionPut {
+		t.Errorf("Action() = %q, want %q", cmd.Action(), ActionPut)
+	}
+	if v, ok := cmd.Key("i"); !ok || v != "10" {
+		t.Errorf("Key(i) = %q, %v", v, ok)
+	}
+	if _, ok := cmd.Key("z"); ok {
+		t.Error("Key(z) should not be set")
+	}
+	if string(cmd.Payload()) != "test" {
+		t.Errorf("Payload() = %q, want %q", cmd.Payload(), "test")
+	}
+}
+
+// TestParseCommandDefaultAction tests that a missing a key defaults to transmit
+func TestParseCommandDefaultAction(t *testing.T) {
+	cmd, err := ParseCommand("\x1b_Gm=0;dGVzdA\x1b\\")
+	if err != nil {
+		t.Fatalf("ParseCommand error: %v", err)
+	}
+	if cmd.Action() != ActionTransmit {
+		t.Errorf("Action() = %q, want %q", cmd.Action(), ActionTransmit)
+	}
+	if string(cmd.Payload()) != "test" {
+		t.Errorf("unpadded payload = %q, want %q", cmd.Payload(), "test")
+	}
+}
+
+// TestParseCommandInvalid tests rejection of malformed commands
+func TestParseCommandInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"Missing markers", "a=t;dGVzdA=="},
+		{"Missing terminator", "\x1b_Ga=t"},
+		{"Invalid pair", "\x1b_Ga=t,i10\x1b\\"},
+		{"Empty key", "\x1b_Ga=t,=1\x1b\\"},
+		{"Duplicate key", "\x1b_Ga=t,i=1,i=2\x1b\\"},
+		{"Invalid payload", "\x1b_Ga=t;!!!!\x1b\\"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCommand(tt.in); err == nil {
+				t.Errorf("expected error for %q", tt.in)
+			}
+		})
+	}
+}
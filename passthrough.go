@@ -0,0 +1,130 @@
+package kgp
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Passthrough selects how graphics sequences are wrapped to pass through a
+// terminal multiplexer to the outer terminal.
+type Passthrough int
+
+const (
+	// PassthroughNone writes sequences unchanged
+	PassthroughNone Passthrough = iota
+	// PassthroughTmux wraps sequences in tmux DCS passthrough; tmux needs
+	// "set -g allow-passthrough on"
+	PassthroughTmux
+	// PassthroughScreen wraps sequences in GNU screen DCS strings
+	PassthroughScreen
+)
+
+// screenMaxDCS is the largest DCS string GNU screen passes through.
+const screenMaxDCS = 768
+
+// DetectPassthrough returns the passthrough needed for the multiplexer the
+// process runs in, based on the TMUX, STY and TERM environment variables.
+func DetectPassthrough() Passthrough {
+	return detectPassthrough(os.Getenv)
+}
+
+func detectPassthrough(getenv func(string) string) Passthrough {
+	switch {
+	case getenv("TMUX") != "":
+		return PassthroughTmux
+	case getenv("STY") != "":
+		return PassthroughScreen
+	}
+	// TMUX is lost across sudo, ssh and some shells. tmux defaults TERM to
+	// screen*, and GNU screen always sets STY, so both mean tmux here.
+	term := getenv("TERM")
+	if strings.HasPrefix(term, "tmux") || strings.HasPrefix(term, "screen") {
+		return PassthroughTmux
+	}
+	return PassthroughNone
+}
+
+// Wrap wraps seq for the multiplexer. For tmux, seq is enclosed in
+// ESC Ptmux; ... ESC \ with every ESC doubled. For screen, seq is split into
+// DCS strings of at most screenMaxDCS bytes, with a break before every
+// backslash that follows an ESC so no string terminator appears inside.
+func (p Passthrough) Wrap(seq string) string {
+	return string(p.appendWrap(nil, []byte(seq)))
+}
+
+func (p Passthrough) appendWrap(dst, seq []byte) []byte {
+	switch p {
+	case PassthroughTmux:
+		dst = append(dst, "\x1bPtmux;"...)
+		for _, b := range seq {
+			if b == '\x1b' {
+				dst = append(dst, '\x1b')
+			}
+			dst = append(dst, b)
+		}
+		return append(dst, "\x1b\\"...)
+
+	case PassthroughScreen:
+		for len(seq) > 0 {
+			n := min(len(seq), screenMaxDCS)
+			for i := 1; i < n; i++ {
+				if seq[i] == '\\' && seq[i-1] == '\x1b' {
+					n = i
+					break
+				}
+			}
+			dst = append(dst, "\x1bP"...)
+			dst = append(dst, seq[:n]...)
+			dst = append(dst, "\x1b\\"...)
+			seq = seq[n:]
+		}
+		return dst
+	}
+	return append(dst, seq...)
+}
+
+// Encode encodes cmd and wraps it for the multiplexer.
+func (p Passthrough) Encode(cmd *Command) string {
+	return string(p.appendWrap(nil, cmd.AppendEncode(nil)))
+}
+
+// EncodeChunked splits cmd into chunks as Command.TryEncodeChunked does and
+// wraps each chunk separately for the multiplexer. It returns
+// ErrInvalidChunkSize for an invalid chunk size.
+func (p Passthrough) EncodeChunked(cmd *Command, chunkSize int) ([]string, error) {
+	chunks, err := cmd.TryEncodeChunked(chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	for i, chunk := range chunks {
+		chunks[i] = p.Wrap(chunk)
+	}
+	return chunks, nil
+}
+
+// Writer returns a writer that wraps every Write for the multiplexer before
+// writing it to w. The multiplexer forwards the wrapped bytes unchanged, so a
+// sequence may span several writes, but writing whole sequences keeps other
+// output of the multiplexer from being interleaved. For PassthroughNone it
+// returns w.
+func (p Passthrough) Writer(w io.Writer) io.Writer {
+	if p == PassthroughNone {
+		return w
+	}
+	return &passthroughWriter{w: w, p: p}
+}
+
+type passthroughWriter struct {
+	w   io.Writer
+	p   Passthrough
+	buf []byte
+}
+
+func (pw *passthroughWriter) Write(b []byte) (int, error) {
+	pw.buf = pw.p.appendWrap(pw.buf[:0], b)
+	if _, err := pw.w.Write(pw.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,121 @@
+package kgp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestDetectPassthrough tests multiplexer detection from the environment
+func TestDetectPassthrough(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Passthrough
+	}{
+		{"plain terminal", map[string]string{"TERM": "xterm-kitty"}, PassthroughNone},
+		{"tmux", map[string]string{"TMUX": "/tmp/tmux-1000/default,1234,0", "TERM": "screen-256color"}, PassthroughTmux},
+		{"tmux TERM", map[string]string{"TERM": "tmux-256color"}, PassthroughTmux},
+		{"screen", map[string]string{"STY": "1234.pts-0.host", "TERM": "screen"}, PassthroughScreen},
+		{"screen TERM without STY", map[string]string{"TERM": "screen-256color"}, PassthroughTmux},
+		{"empty", map[string]string{}, PassthroughNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectPassthrough(func(k string) string { return tt.env[k] })
+			if got != tt.want {
+				t.Errorf("detectPassthrough() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPassthrough_Wrap tests wrapping a sequence for each multiplexer
+func TestPassthrough_Wrap(t *testing.T) {
+	seq := "\x1b_Ga=T,f=100;AAAA\x1b\\"
+
+	if got := PassthroughNone.Wrap(seq); got != seq {
+		t.Errorf("none: %q", got)
+	}
+
+	want := "\x1bPtmux;\x1b\x1b_Ga=T,f=100;AAAA\x1b\x1b\\\x1b\\"
+	if got := PassthroughTmux.Wrap(seq); got != want {
+		t.Errorf("tmux: got %q, want %q", got, want)
+	}
+
+	want = "\x1bP\x1b_Ga=T,f=100;AAAA\x1b\x1b\\\x1bP\\\x1b\\"
+	if got := PassthroughScreen.Wrap(seq); got != want {
+		t.Errorf("screen: got %q, want %q", got, want)
+	}
+}
+
+// TestPassthrough_ScreenLimit tests splitting long sequences for screen
+func TestPassthrough_ScreenLimit(t *testing.T) {
+	cmd := NewTransmit().Format(FormatPNG).TransmitDirect(bytes.Repeat([]byte{0xff}, 2000)).Build()
+	seq := cmd.Encode()
+
+	wrapped := PassthroughScreen.Wrap(seq)
+	var unwrapped strings.Builder
+	for _, part := range strings.Split(wrapped, "\x1bP")[1:] {
+		body, ok := strings.CutSuffix(part, "\x1b\\")
+		if !ok {
+			t.Fatalf("part not terminated: %q", part)
+		}
+		if len(body) > screenMaxDCS {
+			t.Errorf("part of %d bytes exceeds screen limit", len(body))
+		}
+		if strings.Contains(body, "\x1b\\") {
+			t.Errorf("part contains a string terminator: %q", body)
+		}
+		unwrapped.WriteString(body)
+	}
+	if unwrapped.String() != seq {
+		t.Error("unwrapped parts do not match the sequence")
+	}
+}
+
+// TestPassthrough_EncodeChunked tests that every chunk is wrapped
+func TestPassthrough_EncodeChunked(t *testing.T) {
+	cmd := NewTransmit().ImageID(1).Format(FormatPNG).TransmitDirect(bytes.Repeat([]byte{1}, 5000)).Build()
+
+	plain := cmd.EncodeChunked(4096)
+	chunks, err := PassthroughTmux.EncodeChunked(cmd, 4096)
+	if err != nil {
+		t.Fatalf("EncodeChunked error: %v", err)
+	}
+	if len(chunks) != len(plain) || len(chunks) < 2 {
+		t.Fatalf("expected %d chunks, got %d", len(plain), len(chunks))
+	}
+	for i, chunk := range chunks {
+		if chunk != PassthroughTmux.Wrap(plain[i]) {
+			t.Errorf("chunk %d not wrapped: %q", i, chunk[:20])
+		}
+	}
+
+	if got := PassthroughTmux.Encode(cmd); got != PassthroughTmux.Wrap(cmd.Encode()) {
+		t.Error("Encode does not match wrapped Command.Encode")
+	}
+
+	if _, err := PassthroughTmux.EncodeChunked(cmd, 3); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Errorf("expected ErrInvalidChunkSize, got %v", err)
+	}
+}
+
+// TestPassthrough_Writer tests wrapping writes
+func TestPassthrough_Writer(t *testing.T) {
+	var buf bytes.Buffer
+	if w := PassthroughNone.Writer(&buf); w != &buf {
+		t.Error("PassthroughNone should return the writer unchanged")
+	}
+
+	w := PassthroughTmux.Writer(&buf)
+	cmd := NewTransmit().ImageID(3).Format(FormatPNG).TransmitDirect([]byte("png")).Build()
+	if _, err := cmd.WriteTo(w); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if buf.String() != PassthroughTmux.Encode(cmd) {
+		t.Errorf("got %q", buf.String())
+	}
+}
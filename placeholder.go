@@ -0,0 +1,173 @@
+package kgp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PlaceholderRune is the character the terminal replaces with image cells
+// of a virtual placement (U=1).
+const PlaceholderRune = '\U0010EEEE'
+
+// ErrInvalidPlaceholder indicates a placeholder that cannot be encoded.
+var ErrInvalidPlaceholder = errors.New("invalid placeholder")
+
+// rowColumnDiacritics are the combining characters encoding row and column
+// numbers, and the most significant byte of the image ID, in placeholder
+// cells. The diacritic at index n encodes the value n.
+var rowColumnDiacritics = [...]rune{
+	0x0305, 0x030D, 0x030E, 0x0310, 0x0312, 0x033D, 0x033E, 0x033F, 0x0346, 0x034A,
+	0x034B, 0x034C, 0x0350, 0x0351, 0x0352, 0x0357, 0x035B, 0x0363, 0x0364, 0x0365,
+	0x0366, 0x0367, 0x0368, 0x0369, 0x036A, 0x036B, 0x036C, 0x036D, 0x036E, 0x036F,
+	0x0483, 0x0484, 0x0485, 0x0486, 0x0487, 0x0592, 0x0593, 0x0594, 0x0595, 0x0597,
+	0x0598, 0x0599, 0x059C, 0x059D, 0x059E, 0x059F, 0x05A0, 0x05A1, 0x05A8, 0x05A9,
+	0x05AB, 0x05AC, 0x05AF, 0x05C4, 0x0610, 0x0611, 0x0612, 0x0613, 0x0614, 0x0615,
+	0x0616, 0x0617, 0x0657, 0x0658, 0x0659, 0x065A, 0x065B, 0x065D, 0x065E, 0x06D6,
+	0x06D7, 0x06D8, 0x06D9, 0x06DA, 0x06DB, 0x06DC, 0x06DF, 0x06E0, 0x06E1, 0x06E2,
+	0x06E4, 0x06E7, 0x06E8, 0x06EB, 0x06EC, 0x0730, 0x0732, 0x0733, 0x0735, 0x0736,
+	0x073A, 0x073D, 0x073F, 0x0740, 0x0741, 0x0743, 0x0745, 0x0747, 0x0749, 0x074A,
+	0x07EB, 0x07EC, 0x07ED, 0x07EE, 0x07EF, 0x07F0, 0x07F1, 0x07F3, 0x0816, 0x0817,
+	0x0818, 0x0819, 0x081B, 0x081C, 0x081D, 0x081E, 0x081F, 0x0820, 0x0821, 0x0822,
+	0x0823, 0x0825, 0x0826, 0x0827, 0x0829, 0x082A, 0x082B, 0x082C, 0x082D, 0x0951,
+	0x0953, 0x0954, 0x0F82, 0x0F83, 0x0F86, 0x0F87, 0x135D, 0x135E, 0x135F, 0x17DD,
+	0x193A, 0x1A17, 0x1A75, 0x1A76, 0x1A77, 0x1A78, 0x1A79, 0x1A7A, 0x1A7B, 0x1A7C,
+	0x1B6B, 0x1B6D, 0x1B6E, 0x1B6F, 0x1B70, 0x1B71, 0x1B72, 0x1B73, 0x1CD0, 0x1CD1,
+	0x1CD2, 0x1CDA, 0x1CDB, 0x1CE0, 0x1DC0, 0x1DC1, 0x1DC3, 0x1DC4, 0x1DC5, 0x1DC6,
+	0x1DC7, 0x1DC8, 0x1DC9, 0x1DCB, 0x1DCC, 0x1DD1, 0x1DD2, 0x1DD3, 0x1DD4, 0x1DD5,
+	0x1DD6, 0x1DD7, 0x1DD8, 0x1DD9, 0x1DDA, 0x1DDB, 0x1DDC, 0x1DDD, 0x1DDE, 0x1DDF,
+	0x1DE0, 0x1DE1, 0x1DE2, 0x1DE3, 0x1DE4, 0x1DE5, 0x1DE6, 0x1DFE, 0x20D0, 0x20D1,
+	0x20D4, 0x20D5, 0x20D6, 0x20D7, 0x20DB, 0x20DC, 0x20E1, 0x20E7, 0x20E9, 0x20F0,
+	0x2CEF, 0x2CF0, 0x2CF1, 0x2DE0, 0x2DE1, 0x2DE2, 0x2DE3, 0x2DE4, 0x2DE5, 0x2DE6,
+	0x2DE7, 0x2DE8, 0x2DE9, 0x2DEA, 0x2DEB, 0x2DEC, 0x2DED, 0x2DEE, 0x2DEF, 0x2DF0,
+	0x2DF1, 0x2DF2, 0x2DF3, 0x2DF4, 0x2DF5, 0x2DF6, 0x2DF7, 0x2DF8, 0x2DF9, 0x2DFA,
+	0x2DFB, 0x2DFC, 0x2DFD, 0x2DFE, 0x2DFF, 0xA66F, 0xA67C, 0xA67D, 0xA6F0, 0xA6F1,
+	0xA8E0, 0xA8E1, 0xA8E2, 0xA8E3, 0xA8E4, 0xA8E5, 0xA8E6, 0xA8E7, 0xA8E8, 0xA8E9,
+	0xA8EA, 0xA8EB, 0xA8EC, 0xA8ED, 0xA8EE, 0xA8EF, 0xA8F0, 0xA8F1, 0xAAB0, 0xAAB2,
+	0xAAB3, 0xAAB7, 0xAAB8, 0xAABE, 0xAABF, 0xAAC1, 0xFE20, 0xFE21, 0xFE22, 0xFE23,
+	0xFE24, 0xFE25, 0xFE26, 0x10A0F, 0x10A38, 0x1D185, 0x1D186, 0x1D187, 0x1D188, 0x1D189,
+	0x1D1AA, 0x1D1AB, 0x1D1AC, 0x1D1AD, 0x1D242, 0x1D243, 0x1D244,
+}
+
+// MaxPlaceholderCells is the largest number of rows or columns a placeholder can address.
+const MaxPlaceholderCells = len(rowColumnDiacritics)
+
+// Placeholder produces the Unicode placeholder text that displays a virtual
+// placement. Each cell is PlaceholderRune followed by diacritics for its row,
+// column and, when needed, the most significant byte of the image ID. The
+// image ID is encoded in the foreground colour and the placement ID, if any,
+// in the underline colour.
+type Placeholder struct {
+	ImageID     uint32
+	PlacementID uint32
+	Rows        int
+	Columns     int
+	// Use256Colors encodes the IDs as 256-colour indices instead of 24-bit
+	// colours, for terminals and multiplexers without true colour. Only the
+	// least and most significant bytes of the image ID can then be non-zero,
+	// and placement IDs are limited to 8 bits.
+	Use256Colors bool
+}
+
+// Validate checks that the placeholder can be encoded.
+func (p Placeholder) Validate() error {
+	switch {
+	case p.ImageID == 0:
+		return fmt.Errorf("%w: image ID must be non-zero", ErrInvalidPlaceholder)
+	case p.Rows <= 0 || p.Columns <= 0:
+		return fmt.Errorf("%w: size must be positive", ErrInvalidPlaceholder)
+	case p.Rows > MaxPlaceholderCells || p.Columns > MaxPlaceholderCells:
+		return fmt.Errorf("%w: size %dx%d exceeds %d cells", ErrInvalidPlaceholder, p.Columns, p.Rows, MaxPlaceholderCells)
+	case p.Use256Colors && p.ImageID&0xffff00 != 0:
+		return fmt.Errorf("%w: image ID %#x has middle bytes set in 256-colour mode", ErrInvalidPlaceholder, p.ImageID)
+	case p.Use256Colors && p.PlacementID > 0xff:
+		return fmt.Errorf("%w: placement ID %d exceeds 8 bits in 256-colour mode", ErrInvalidPlaceholder, p.PlacementID)
+	case p.PlacementID > 0xffffff:
+		return fmt.Errorf("%w: placement ID %d exceeds 24 bits", ErrInvalidPlaceholder, p.PlacementID)
+	}
+	return nil
+}
+
+// Put returns a builder for the virtual placement the placeholder displays.
+func (p Placeholder) Put() *PutBuilder {
+	pb := NewPut(p.ImageID).VirtualPlacement().DisplaySize(p.Columns, p.Rows)
+	if p.PlacementID != 0 {
+		pb.PlacementID(p.PlacementID)
+	}
+	return pb
+}
+
+// Style returns the SGR sequence selecting the colours that encode the image
+// and placement IDs. Text written after it, up to a reset of the foreground
+// and underline colours, must consist of placeholder cells.
+func (p Placeholder) Style() string {
+	var sb strings.Builder
+	sb.WriteString("\x1b[")
+	if p.Use256Colors {
+		sb.WriteString("38;5;")
+		sb.WriteString(strconv.Itoa(int(p.ImageID & 0xff)))
+	} else {
+		writeRGB(&sb, "38;2;", p.ImageID)
+	}
+	if p.PlacementID != 0 {
+		if p.Use256Colors {
+			sb.WriteString(";58;5;")
+			sb.WriteString(strconv.Itoa(int(p.PlacementID)))
+		} else {
+			writeRGB(&sb, ";58;2;", p.PlacementID)
+		}
+	}
+	sb.WriteByte('m')
+	return sb.String()
+}
+
+func writeRGB(sb *strings.Builder, prefix string, v uint32) {
+	sb.WriteString(prefix)
+	sb.WriteString(strconv.Itoa(int(v >> 16 & 0xff)))
+	sb.WriteByte(';')
+	sb.WriteString(strconv.Itoa(int(v >> 8 & 0xff)))
+	sb.WriteByte(';')
+	sb.WriteString(strconv.Itoa(int(v & 0xff)))
+}
+
+// highByte returns the most significant byte of the image ID, which is
+// encoded in the third diacritic.
+func (p Placeholder) highByte() uint32 {
+	return p.ImageID >> 24
+}
+
+// Cell returns the text of the placeholder cell at row and column, without
+// colours. It is meant for cell buffers that manage colours themselves; the
+// cell must be drawn with the colours selected by Style. row and column must
+// be less than MaxPlaceholderCells.
+func (p Placeholder) Cell(row, column int) string {
+	cell := []rune{PlaceholderRune, rowColumnDiacritics[row], rowColumnDiacritics[column]}
+	if high := p.highByte(); high != 0 {
+		cell = append(cell, rowColumnDiacritics[high])
+	}
+	return string(cell)
+}
+
+// Lines returns one line of text per row of the placeholder. Each line
+// selects the ID colours, contains a cell for every column and resets the
+// foreground and underline colours; the caller positions the cursor at the
+// start of each line.
+func (p Placeholder) Lines() ([]string, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	style := p.Style()
+	lines := make([]string, p.Rows)
+	for row := range lines {
+		var sb strings.Builder
+		sb.WriteString(style)
+		for col := 0; col < p.Columns; col++ {
+			sb.WriteString(p.Cell(row, col))
+		}
+		sb.WriteString("\x1b[39;59m")
+		lines[row] = sb.String()
+	}
+	return lines, nil
+}
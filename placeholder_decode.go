@@ -0,0 +1,296 @@
+package kgp
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ColorKind is the way a terminal colour was specified.
+type ColorKind uint8
+
+const (
+	// ColorDefault is the terminal's default colour
+	ColorDefault ColorKind = iota
+	// ColorIndexed is a palette colour (SGR 30-37, 90-97 or 38;5;n)
+	ColorIndexed
+	// ColorRGB is a 24-bit colour (SGR 38;2;r;g;b)
+	ColorRGB
+)
+
+// CellColor is a foreground or underline colour of a terminal cell.
+type CellColor struct {
+	Kind ColorKind
+	// Value is the palette index for ColorIndexed, or 0xRRGGBB for ColorRGB.
+	Value uint32
+}
+
+// IndexedColor returns a palette colour.
+func IndexedColor(index uint8) CellColor {
+	return CellColor{Kind: ColorIndexed, Value: uint32(index)}
+}
+
+// RGBColor returns a 24-bit colour.
+func RGBColor(r, g, b uint8) CellColor {
+	return CellColor{Kind: ColorRGB, Value: uint32(r)<<16 | uint32(g)<<8 | uint32(b)}
+}
+
+// id returns the ID encoded by the colour; the default colour encodes 0.
+func (c CellColor) id() uint32 {
+	if c.Kind == ColorDefault {
+		return 0
+	}
+	return c.Value
+}
+
+// PlaceholderCell is the image cell referenced by a placeholder.
+type PlaceholderCell struct {
+	ImageID     uint32
+	PlacementID uint32
+	Row         int
+	Column      int
+	// X is the position of the cell within the decoded line. It is only set
+	// by DecodePlaceholderLine.
+	X int
+}
+
+// PlaceholderDecoder recovers the image cell referenced by each placeholder
+// in a row of terminal cells. Cells must be decoded left to right, because
+// diacritics omitted from a cell are inferred from the cell before it, as
+// the terminal does:
+//
+//   - with no diacritics, a cell continues the row of the previous
+//     placeholder cell with the next column;
+//   - with only a row diacritic, it takes the next column if the previous
+//     cell is on the same row;
+//   - with row and column diacritics, it takes the most significant byte of
+//     the image ID from the previous cell if it is the next column on the
+//     same row.
+//
+// Inference only applies when the previous cell is a placeholder with the
+// same foreground and underline colours; otherwise missing values are 0.
+type PlaceholderDecoder struct {
+	prev     PlaceholderCell
+	prevHigh uint32
+	prevFG   CellColor
+	prevUL   CellColor
+	hasPrev  bool
+}
+
+// Reset forgets the previous cell. Call it at the start of each row.
+func (d *PlaceholderDecoder) Reset() {
+	d.hasPrev = false
+}
+
+// DecodeCell decodes the cell containing text drawn with the given
+// foreground and underline colours. It reports false if the cell is not a
+// placeholder.
+func (d *PlaceholderDecoder) DecodeCell(text string, fg, underline CellColor) (PlaceholderCell, bool) {
+	r, size := utf8.DecodeRuneInString(text)
+	if r != PlaceholderRune {
+		d.hasPrev = false
+		return PlaceholderCell{}, false
+	}
+
+	var values [3]int
+	n := 0
+	for _, r := range text[size:] {
+		v, ok := diacriticValue(r)
+		if !ok || n == len(values) {
+			break
+		}
+		values[n] = v
+		n++
+	}
+
+	var cell PlaceholderCell
+	var high uint32
+	inherit := d.hasPrev && fg == d.prevFG && underline == d.prevUL
+	switch n {
+	case 0:
+		if inherit {
+			cell.Row, cell.Column, high = d.prev.Row, d.prev.Column+1, d.prevHigh
+		}
+	case 1:
+		cell.Row = values[0]
+		if inherit && cell.Row == d.prev.Row {
+			cell.Column, high = d.prev.Column+1, d.prevHigh
+		}
+	case 2:
+		cell.Row, cell.Column = values[0], values[1]
+		if inherit && cell.Row == d.prev.Row && cell.Column == d.prev.Column+1 {
+			high = d.prevHigh
+		}
+	default:
+		cell.Row, cell.Column, high = values[0], values[1], uint32(values[2])
+	}
+	cell.ImageID = fg.id() | high<<24
+	cell.PlacementID = underline.id()
+
+	d.prev, d.prevHigh, d.prevFG, d.prevUL, d.hasPrev = cell, high, fg, underline, true
+	return cell, true
+}
+
+// diacriticValue returns the value encoded by a row/column diacritic.
+func diacriticValue(r rune) (int, bool) {
+	return slices.BinarySearch(rowColumnDiacritics[:], r)
+}
+
+// DecodePlaceholderLine decodes the placeholder cells in a line of terminal
+// output, tracking the foreground and underline colours set by SGR
+// sequences from their defaults at the start of the line. Other escape
+// sequences are skipped. Each character other than a combining mark is
+// counted as one cell for the X position of the returned cells.
+func DecodePlaceholderLine(line string) []PlaceholderCell {
+	var (
+		d     PlaceholderDecoder
+		fg    CellColor
+		ul    CellColor
+		cells []PlaceholderCell
+		x     int
+	)
+
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			i = skipEscape(line, i, &fg, &ul)
+			continue
+		}
+
+		// A cell is a character and the combining marks following it
+		_, size := utf8.DecodeRuneInString(line[i:])
+		end := i + size
+		for end < len(line) {
+			r, size := utf8.DecodeRuneInString(line[end:])
+			if !unicode.Is(unicode.Mn, r) {
+				break
+			}
+			end += size
+		}
+
+		if cell, ok := d.DecodeCell(line[i:end], fg, ul); ok {
+			cell.X = x
+			cells = append(cells, cell)
+		}
+		x++
+		i = end
+	}
+	return cells
+}
+
+// skipEscape skips the escape sequence starting at line[i], applying SGR
+// sequences to fg and ul, and returns the index following it.
+func skipEscape(line string, i int, fg, ul *CellColor) int {
+	i++
+	if i >= len(line) {
+		return i
+	}
+	switch line[i] {
+	case '[':
+		start := i + 1
+		for j := start; j < len(line); j++ {
+			if c := line[j]; c >= 0x40 && c <= 0x7e {
+				if c == 'm' {
+					applySGR(line[start:j], fg, ul)
+				}
+				return j + 1
+			}
+		}
+		return len(line)
+	case ']', 'P', '_':
+		// String sequences end with BEL or ST
+		for j := i + 1; j < len(line); j++ {
+			if line[j] == '\a' {
+				return j + 1
+			}
+			if line[j] == '\x1b' && j+1 < len(line) && line[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return len(line)
+	}
+	return i + 1
+}
+
+// applySGR applies the colour changes of an SGR parameter string.
+func applySGR(params string, fg, ul *CellColor) {
+	groups := strings.Split(params, ";")
+	for i := 0; i < len(groups); i++ {
+		if sub := strings.Split(groups[i], ":"); len(sub) > 1 {
+			// Colon-separated form, e.g. 38:2::r:g:b or 58:5:n
+			if c, n := extendedColor(sub[1:], true); n > 0 {
+				setColor(sub[0], c, fg, ul)
+			}
+			continue
+		}
+
+		switch code, _ := strconv.Atoi(groups[i]); {
+		case code == 0:
+			*fg, *ul = CellColor{}, CellColor{}
+		case code >= 30 && code <= 37:
+			*fg = IndexedColor(uint8(code - 30))
+		case code >= 90 && code <= 97:
+			*fg = IndexedColor(uint8(code - 90 + 8))
+		case code == 39:
+			*fg = CellColor{}
+		case code == 59:
+			*ul = CellColor{}
+		case code == 38 || code == 58:
+			c, n := extendedColor(groups[i+1:], false)
+			if n == 0 {
+				return
+			}
+			setColor(groups[i], c, fg, ul)
+			i += n
+		}
+	}
+}
+
+// extendedColor parses the arguments of SGR 38 or 58, returning the colour
+// and the number of arguments used, or 0 if they are malformed. In the colon
+// form the 24-bit colour may be preceded by a colour space ID.
+func extendedColor(args []string, colon bool) (CellColor, int) {
+	if len(args) == 0 {
+		return CellColor{}, 0
+	}
+	switch args[0] {
+	case "5":
+		if len(args) < 2 {
+			return CellColor{}, 0
+		}
+		index, err := strconv.ParseUint(args[1], 10, 8)
+		if err != nil {
+			return CellColor{}, 0
+		}
+		return IndexedColor(uint8(index)), 2
+	case "2":
+		rgb := args[1:]
+		if colon && len(rgb) >= 4 {
+			rgb = rgb[1:]
+		}
+		if len(rgb) < 3 {
+			return CellColor{}, 0
+		}
+		var v [3]uint8
+		for j := range v {
+			n, err := strconv.ParseUint(rgb[j], 10, 8)
+			if err != nil {
+				return CellColor{}, 0
+			}
+			v[j] = uint8(n)
+		}
+		return RGBColor(v[0], v[1], v[2]), 4
+	}
+	return CellColor{}, 0
+}
+
+// setColor sets the foreground (code 38) or underline (code 58) colour.
+func setColor(code string, c CellColor, fg, ul *CellColor) {
+	switch code {
+	case "38":
+		*fg = c
+	case "58":
+		*ul = c
+	}
+}
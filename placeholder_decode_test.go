@@ -0,0 +1,171 @@
+package kgp
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDecodePlaceholderLine_RoundTrip tests decoding lines produced by Placeholder
+func TestDecodePlaceholderLine_RoundTrip(t *testing.T) {
+	tests := []Placeholder{
+		{ImageID: 0x123456, PlacementID: 7, Rows: 2, Columns: 3},
+		{ImageID: 0xab000001, Rows: 1, Columns: 2},
+		{ImageID: 0x0300002a, PlacementID: 9, Rows: 2, Columns: 2, Use256Colors: true},
+	}
+
+	for _, p := range tests {
+		lines, err := p.Lines()
+		if err != nil {
+			t.Fatalf("Lines error: %v", err)
+		}
+		for row, line := range lines {
+			cells := DecodePlaceholderLine("text " + line + " more")
+			if len(cells) != p.Columns {
+				t.Fatalf("%+v row %d: expected %d cells, got %d", p, row, p.Columns, len(cells))
+			}
+			for col, cell := range cells {
+				want := PlaceholderCell{ImageID: p.ImageID, PlacementID: p.PlacementID, Row: row, Column: col, X: 5 + col}
+				if cell != want {
+					t.Errorf("cell = %+v, want %+v", cell, want)
+				}
+			}
+		}
+	}
+}
+
+// TestPlaceholderDecoder_Inference tests inference of omitted diacritics
+func TestPlaceholderDecoder_Inference(t *testing.T) {
+	const (
+		ph = "\U0010EEEE"
+		d0 = "\u0305" // 0
+		d1 = "\u030d" // 1
+		d2 = "\u030e" // 2
+		d3 = "\u0310" // 3
+	)
+	fg := RGBColor(0, 0, 42)
+	other := RGBColor(0, 0, 43)
+
+	type input struct {
+		text string
+		fg   CellColor
+	}
+	tests := []struct {
+		name  string
+		cells []input
+		want  []PlaceholderCell
+	}{
+		{
+			name:  "no diacritics continue the previous cell",
+			cells: []input{{ph + d1 + d2 + d3, fg}, {ph, fg}, {ph, fg}},
+			want: []PlaceholderCell{
+				{ImageID: 0x0300002a, Row: 1, Column: 2},
+				{ImageID: 0x0300002a, Row: 1, Column: 3},
+				{ImageID: 0x0300002a, Row: 1, Column: 4},
+			},
+		},
+		{
+			name:  "no diacritics without previous cell",
+			cells: []input{{ph, fg}},
+			want:  []PlaceholderCell{{ImageID: 42}},
+		},
+		{
+			name:  "row only on the same row",
+			cells: []input{{ph + d1 + d1 + d2, fg}, {ph + d1, fg}},
+			want: []PlaceholderCell{
+				{ImageID: 0x0200002a, Row: 1, Column: 1},
+				{ImageID: 0x0200002a, Row: 1, Column: 2},
+			},
+		},
+		{
+			name:  "row only on a different row",
+			cells: []input{{ph + d1 + d1 + d2, fg}, {ph + d2, fg}},
+			want: []PlaceholderCell{
+				{ImageID: 0x0200002a, Row: 1, Column: 1},
+				{ImageID: 42, Row: 2},
+			},
+		},
+		{
+			name:  "row and column inherit the high byte when consecutive",
+			cells: []input{{ph + d0 + d0 + d3, fg}, {ph + d0 + d1, fg}, {ph + d0 + d3, fg}},
+			want: []PlaceholderCell{
+				{ImageID: 0x0300002a},
+				{ImageID: 0x0300002a, Column: 1},
+				{ImageID: 42, Column: 3},
+			},
+		},
+		{
+			name:  "different colours prevent inference",
+			cells: []input{{ph + d1 + d1, fg}, {ph, other}},
+			want: []PlaceholderCell{
+				{ImageID: 42, Row: 1, Column: 1},
+				{ImageID: 43},
+			},
+		},
+		{
+			name:  "non-placeholder cell breaks inference",
+			cells: []input{{ph + d1 + d1, fg}, {"x", fg}, {ph, fg}},
+			want: []PlaceholderCell{
+				{ImageID: 42, Row: 1, Column: 1},
+				{ImageID: 42},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d PlaceholderDecoder
+			var got []PlaceholderCell
+			for _, in := range tt.cells {
+				if cell, ok := d.DecodeCell(in.text, in.fg, CellColor{}); ok {
+					got = append(got, cell)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cells = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPlaceholderDecoder_Reset tests that Reset stops inference across rows
+func TestPlaceholderDecoder_Reset(t *testing.T) {
+	var d PlaceholderDecoder
+	fg := IndexedColor(5)
+	d.DecodeCell("\U0010EEEE\u030d\u030d", fg, CellColor{})
+	d.Reset()
+	cell, ok := d.DecodeCell("\U0010EEEE", fg, CellColor{})
+	if !ok || cell != (PlaceholderCell{ImageID: 5}) {
+		t.Errorf("cell = %+v, %v", cell, ok)
+	}
+}
+
+// TestDecodePlaceholderLine_SGR tests colour tracking across SGR forms
+func TestDecodePlaceholderLine_SGR(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want PlaceholderCell
+	}{
+		{"basic palette", "\x1b[1;35m\U0010EEEE", PlaceholderCell{ImageID: 5}},
+		{"bright palette", "\x1b[92m\U0010EEEE", PlaceholderCell{ImageID: 10}},
+		{"256 colours", "\x1b[38;5;200;58;5;3m\U0010EEEE", PlaceholderCell{ImageID: 200, PlacementID: 3}},
+		{"24-bit", "\x1b[38;2;1;2;3m\x1b[58;2;0;1;0m\U0010EEEE", PlaceholderCell{ImageID: 0x010203, PlacementID: 256}},
+		{"colon form", "\x1b[38:2::1:2:3;58:5:4m\U0010EEEE", PlaceholderCell{ImageID: 0x010203, PlacementID: 4}},
+		{"colon form without colour space", "\x1b[38:2:1:2:3m\U0010EEEE", PlaceholderCell{ImageID: 0x010203}},
+		{"reset", "\x1b[38;5;9;58;5;3m\x1b[m\U0010EEEE", PlaceholderCell{}},
+		{"default colours", "\x1b[38;5;9;58;5;3m\x1b[39;59m\U0010EEEE", PlaceholderCell{}},
+		{"other sequences skipped", "\x1b[31m\x1b]0;title\a\x1b[2K\U0010EEEE", PlaceholderCell{ImageID: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells := DecodePlaceholderLine(tt.line)
+			if len(cells) != 1 {
+				t.Fatalf("expected 1 cell, got %d", len(cells))
+			}
+			if cells[0] != tt.want {
+				t.Errorf("cell = %+v, want %+v", cells[0], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package kgp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestPlaceholder_Lines tests encoding placeholder lines with 24-bit colours
+func TestPlaceholder_Lines(t *testing.T) {
+	p := Placeholder{ImageID: 0x123456, PlacementID: 7, Rows: 2, Columns: 3}
+
+	lines, err := p.Lines()
+	if err != nil {
+		t.Fatalf("Lines error: %v", err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+
+	wantRow1 := "\x1b[38;2;18;52;86;58;2;0;0;7m" +
+		"\U0010EEEE\u030d\u0305" +
+		"\U0010EEEE\u030d\u030d" +
+		"\U0010EEEE\u030d\u030e" +
+		"\x1b[39;59m"
+	if lines[1] != wantRow1 {
+		t.Errorf("line 1 = %q, want %q", lines[1], wantRow1)
+	}
+	if !strings.HasPrefix(lines[0], "\x1b[38;2;18;52;86;58;2;0;0;7m\U0010EEEE\u0305\u0305") {
+		t.Errorf("line 0 = %q", lines[0])
+	}
+}
+
+// TestPlaceholder_HighByte tests the third diacritic for large image IDs
+func TestPlaceholder_HighByte(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     Placeholder
+		style string
+		cell  string
+	}{
+		{
+			name:  "24-bit without high byte",
+			p:     Placeholder{ImageID: 0xffffff, Rows: 1, Columns: 1},
+			style: "\x1b[38;2;255;255;255m",
+			cell:  "\U0010EEEE\u0305\u0305",
+		},
+		{
+			name:  "24-bit with high byte",
+			p:     Placeholder{ImageID: 0x02000001, Rows: 1, Columns: 1},
+			style: "\x1b[38;2;0;0;1m",
+			cell:  "\U0010EEEE\u0305\u0305\u030e",
+		},
+		{
+			name:  "256 colours",
+			p:     Placeholder{ImageID: 42, Rows: 1, Columns: 1, Use256Colors: true},
+			style: "\x1b[38;5;42m",
+			cell:  "\U0010EEEE\u0305\u0305",
+		},
+		{
+			name:  "256 colours with high byte",
+			p:     Placeholder{ImageID: 0x0100002a, PlacementID: 3, Rows: 1, Columns: 1, Use256Colors: true},
+			style: "\x1b[38;5;42;58;5;3m",
+			cell:  "\U0010EEEE\u0305\u0305\u030d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Style(); got != tt.style {
+				t.Errorf("Style() = %q, want %q", got, tt.style)
+			}
+			if got := tt.p.Cell(0, 0); got != tt.cell {
+				t.Errorf("Cell(0, 0) = %q, want %q", got, tt.cell)
+			}
+		})
+	}
+}
+
+// TestPlaceholder_Validate tests rejection of placeholders that cannot be encoded
+func TestPlaceholder_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Placeholder
+	}{
+		{"zero image ID", Placeholder{Rows: 1, Columns: 1}},
+		{"zero size", Placeholder{ImageID: 1}},
+		{"too many rows", Placeholder{ImageID: 1, Rows: MaxPlaceholderCells + 1, Columns: 1}},
+		{"placement ID too large", Placeholder{ImageID: 1, PlacementID: 1 << 24, Rows: 1, Columns: 1}},
+		{"256-colour image ID with middle bytes", Placeholder{ImageID: 1 << 8, Rows: 1, Columns: 1, Use256Colors: true}},
+		{"256-colour placement ID too large", Placeholder{ImageID: 1, PlacementID: 256, Rows: 1, Columns: 1, Use256Colors: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.p.Lines(); !errors.Is(err, ErrInvalidPlaceholder) {
+				t.Errorf("expected ErrInvalidPlaceholder, got %v", err)
+			}
+		})
+	}
+
+	p := Placeholder{ImageID: 1, Rows: MaxPlaceholderCells, Columns: MaxPlaceholderCells}
+	if err := p.Validate(); err != nil {
+		t.Errorf("maximum size rejected: %v", err)
+	}
+}
+
+// TestPlaceholder_Put tests the virtual placement command for a placeholder
+func TestPlaceholder_Put(t *testing.T) {
+	cmd := Placeholder{ImageID: 5, PlacementID: 2, Rows: 3, Columns: 4}.Put().Build()
+
+	want := map[string]string{"a": "p", "i": "5", "p": "2", "U": "1", "c": "4", "r": "3"}
+	for k, v := range want {
+		if cmd.controlData[k] != v {
+			t.Errorf("key %s = %q, want %q", k, cmd.controlData[k], v)
+		}
+	}
+	if err := cmd.Validate(); err != nil {
+		t.Errorf("Validate error: %v", err)
+	}
+}
+
+// TestRowColumnDiacritics tests the diacritics table
+func TestRowColumnDiacritics(t *testing.T) {
+	if MaxPlaceholderCells != 297 {
+		t.Errorf("expected 297 diacritics, got %d", MaxPlaceholderCells)
+	}
+	for i := 1; i < len(rowColumnDiacritics); i++ {
+		if rowColumnDiacritics[i] <= rowColumnDiacritics[i-1] {
+			t.Fatalf("table not sorted at index %d", i)
+		}
+	}
+	if rowColumnDiacritics[0] != 0x0305 || rowColumnDiacritics[len(rowColumnDiacritics)-1] != 0x1D244 {
+		t.Error("unexpected table bounds")
+	}
+}
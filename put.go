@@ -49,6 +49,13 @@ func (pb *PutBuilder) SourceRect(x, y, width, height int) *PutBuilder {
 	return pb
 }
 
+// Layout sets the display size, cell offset and source rectangle computed by Fit.
+// The cursor must be moved to the layout's Column and Row within the box first.
+func (pb *PutBuilder) Layout(l Layout) *PutBuilder {
+	l.apply(pb.cmd)
+	return pb
+}
+
 // ZIndex sets the z-index (negative = below text, positive = above text).
 func (pb *PutBuilder) ZIndex(z int) *PutBuilder {
 	pb.cmd.SetKeyInt("z", z)
@@ -90,3 +97,13 @@ func (pb *PutBuilder) ResponseSuppression(mode ResponseSuppression) *PutBuilder
 func (pb *PutBuilder) Build() *Command {
 	return pb.cmd
 }
+
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (pb *PutBuilder) BuildChecked() (*Command, error) {
+	cmd := pb.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
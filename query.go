@@ -42,6 +42,16 @@ func (qb *QueryBuilder) Build() *Command {
 	return qb.cmd
 }
 
+// BuildChecked constructs the final command and validates it against the
+// protocol specification.
+func (qb *QueryBuilder) BuildChecked() (*Command, error) {
+	cmd := qb.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
 // QuerySupport returns a query command to check if the terminal supports
 // the Kitty Graphics Protocol. The terminal will respond with OK if supported.
 func QuerySupport() *Command {
@@ -0,0 +1,234 @@
+package kgp
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// MaxRelativeDepth is the longest chain of parent links from a relative
+// placement to its root that the terminal accepts.
+const MaxRelativeDepth = 8
+
+// PlacementTree models relative placements on the client, so cycles and
+// overly deep chains are rejected before anything is sent and the absolute
+// position of every placement is known. The terminal moves relative
+// placements along with their parent, so a whole subtree is moved by
+// replacing only its root. A PlacementTree is not safe for concurrent use.
+type PlacementTree struct {
+	nodes map[placementKey]*treeNode
+	added int
+}
+
+type treeNode struct {
+	p         Placement
+	parent    *treeNode
+	children  []*treeNode
+	offsetH   int
+	offsetV   int
+	insertion int
+}
+
+// NewPlacementTree creates an empty tree.
+func NewPlacementTree() *PlacementTree {
+	return &PlacementTree{nodes: make(map[placementKey]*treeNode)}
+}
+
+// Add adds a root placement at the cell given by p.Row and p.Column. An
+// existing placement with the same IDs is replaced and becomes a root,
+// keeping its children.
+func (t *PlacementTree) Add(p Placement) error {
+	if p.ImageID == 0 || p.PlacementID == 0 {
+		return ErrInvalidPlacement
+	}
+	n := t.node(p.key())
+	n.p = p
+	n.setParent(nil)
+	n.offsetH, n.offsetV = 0, 0
+	return nil
+}
+
+// AddRelative adds a placement positioned relative to a parent placement,
+// offsetH columns and offsetV rows from the parent's top-left cell. The Row
+// and Column of p are ignored. An existing placement with the same IDs is
+// replaced and moved under the new parent, keeping its children.
+//
+// It returns an error wrapping ErrNoParent if the parent is not in the tree,
+// ErrCycle if the parent is the placement itself or one of its descendants,
+// and ErrTooDeep if a chain would exceed MaxRelativeDepth.
+func (t *PlacementTree) AddRelative(p Placement, parentImageID, parentPlacementID uint32, offsetH, offsetV int) error {
+	if p.ImageID == 0 || p.PlacementID == 0 {
+		return ErrInvalidPlacement
+	}
+	parent, ok := t.nodes[placementKey{parentImageID, parentPlacementID}]
+	if !ok {
+		return fmt.Errorf("%w: placement %d/%d", ErrNoParent, parentImageID, parentPlacementID)
+	}
+
+	existing := t.nodes[p.key()]
+	height := 0
+	if existing != nil {
+		for a := parent; a != nil; a = a.parent {
+			if a == existing {
+				return fmt.Errorf("%w: placement %d/%d cannot be relative to itself or a descendant",
+					ErrCycle, p.ImageID, p.PlacementID)
+			}
+		}
+		height = existing.height()
+	}
+	if depth := parent.depth() + 1 + height; depth > MaxRelativeDepth {
+		return fmt.Errorf("%w: chain of %d relative placements exceeds %d", ErrTooDeep, depth, MaxRelativeDepth)
+	}
+
+	n := t.node(p.key())
+	n.p = p
+	n.setParent(parent)
+	n.offsetH, n.offsetV = offsetH, offsetV
+	return nil
+}
+
+// node returns the node for key, creating it if needed.
+func (t *PlacementTree) node(key placementKey) *treeNode {
+	n, ok := t.nodes[key]
+	if !ok {
+		n = &treeNode{insertion: t.added}
+		t.nodes[key] = n
+		t.added++
+	}
+	return n
+}
+
+func (n *treeNode) setParent(parent *treeNode) {
+	if n.parent != nil {
+		n.parent.children = slices.DeleteFunc(n.parent.children, func(c *treeNode) bool { return c == n })
+	}
+	n.parent = parent
+	if parent != nil {
+		parent.children = append(parent.children, n)
+	}
+}
+
+// depth returns the number of parent links to the root.
+func (n *treeNode) depth() int {
+	d := 0
+	for a := n.parent; a != nil; a = a.parent {
+		d++
+	}
+	return d
+}
+
+// height returns the length of the longest chain of descendants.
+func (n *treeNode) height() int {
+	h := 0
+	for _, c := range n.children {
+		h = max(h, c.height()+1)
+	}
+	return h
+}
+
+// Remove removes a placement and its descendants, which the terminal
+// deletes along with their parent.
+func (t *PlacementTree) Remove(imageID, placementID uint32) {
+	n, ok := t.nodes[placementKey{imageID, placementID}]
+	if !ok {
+		return
+	}
+	n.setParent(nil)
+	t.removeSubtree(n)
+}
+
+func (t *PlacementTree) removeSubtree(n *treeNode) {
+	delete(t.nodes, n.p.key())
+	for _, c := range n.children {
+		t.removeSubtree(c)
+	}
+}
+
+// Position returns the absolute zero-based cell of a placement's top-left
+// corner, or an error wrapping ErrNotFound.
+func (t *PlacementTree) Position(imageID, placementID uint32) (row, column int, err error) {
+	n, ok := t.nodes[placementKey{imageID, placementID}]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: placement %d/%d", ErrNotFound, imageID, placementID)
+	}
+	row, column = n.position()
+	return row, column, nil
+}
+
+func (n *treeNode) position() (row, column int) {
+	for ; n.parent != nil; n = n.parent {
+		row += n.offsetV
+		column += n.offsetH
+	}
+	return row + n.p.Row, column + n.p.Column
+}
+
+// command returns the put command creating the placement.
+func (n *treeNode) command() *Command {
+	cmd := n.p.command()
+	if n.parent != nil {
+		cmd.SetKeyUint32("P", n.parent.p.ImageID)
+		cmd.SetKeyUint32("Q", n.parent.p.PlacementID)
+		cmd.SetKeyInt("H", n.offsetH)
+		cmd.SetKeyInt("V", n.offsetV)
+	}
+	return cmd
+}
+
+// appendPut appends the command creating the placement, preceded by a
+// cursor movement for roots.
+func (n *treeNode) appendPut(buf []byte) []byte {
+	if n.parent == nil {
+		buf = appendCursorPosition(buf, n.p.Row, n.p.Column)
+	}
+	return n.command().AppendEncode(buf)
+}
+
+// Place writes the commands creating every placement, parents before their
+// children, in a single write. The cursor is saved and restored around them.
+func (t *PlacementTree) Place(w io.Writer) error {
+	roots := make([]*treeNode, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		if n.parent == nil {
+			roots = append(roots, n)
+		}
+	}
+	slices.SortFunc(roots, func(a, b *treeNode) int { return cmp.Compare(a.insertion, b.insertion) })
+
+	buf := []byte("\x1b7")
+	var walk func(n *treeNode)
+	walk = func(n *treeNode) {
+		buf = n.appendPut(buf)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	for _, n := range roots {
+		walk(n)
+	}
+	buf = append(buf, "\x1b8"...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Move moves a root placement, and with it all its descendants, to a new
+// cell by writing a single put replacing the root. It returns an error
+// wrapping ErrNotFound for unknown placements and ErrInvalidArgument for
+// relative placements, which move with their parent.
+func (t *PlacementTree) Move(w io.Writer, imageID, placementID uint32, row, column int) error {
+	n, ok := t.nodes[placementKey{imageID, placementID}]
+	if !ok {
+		return fmt.Errorf("%w: placement %d/%d", ErrNotFound, imageID, placementID)
+	}
+	if n.parent != nil {
+		return fmt.Errorf("%w: placement %d/%d is relative to a parent", ErrInvalidArgument, imageID, placementID)
+	}
+
+	n.p.Row, n.p.Column = row, column
+	buf := []byte("\x1b7")
+	buf = n.appendPut(buf)
+	buf = append(buf, "\x1b8"...)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,169 @@
+package kgp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestPlacementTree_Position tests absolute positions of relative placements
+func TestPlacementTree_Position(t *testing.T) {
+	tree := NewPlacementTree()
+	if err := tree.Add(Placement{ImageID: 1, PlacementID: 1, Row: 5, Column: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 2, PlacementID: 1}, 1, 1, 3, -2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 3, PlacementID: 1}, 2, 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		image    uint32
+		row, col int
+	}{{1, 5, 10}, {2, 3, 13}, {3, 4, 14}}
+	for _, tt := range tests {
+		row, col, err := tree.Position(tt.image, 1)
+		if err != nil || row != tt.row || col != tt.col {
+			t.Errorf("Position(%d) = %d, %d, %v; want %d, %d", tt.image, row, col, err, tt.row, tt.col)
+		}
+	}
+
+	if _, _, err := tree.Position(9, 9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestPlacementTree_Errors tests rejection of invalid relations
+func TestPlacementTree_Errors(t *testing.T) {
+	tree := NewPlacementTree()
+	tree.Add(Placement{ImageID: 1, PlacementID: 1})
+	tree.AddRelative(Placement{ImageID: 2, PlacementID: 1}, 1, 1, 0, 0)
+	tree.AddRelative(Placement{ImageID: 3, PlacementID: 1}, 2, 1, 0, 0)
+
+	if err := tree.AddRelative(Placement{ImageID: 4, PlacementID: 1}, 9, 9, 0, 0); !errors.Is(err, ErrNoParent) {
+		t.Errorf("missing parent: got %v", err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 1, PlacementID: 1}, 3, 1, 0, 0); !errors.Is(err, ErrCycle) {
+		t.Errorf("cycle through descendant: got %v", err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 2, PlacementID: 1}, 2, 1, 0, 0); !errors.Is(err, ErrCycle) {
+		t.Errorf("relative to itself: got %v", err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 4}, 1, 1, 0, 0); !errors.Is(err, ErrInvalidPlacement) {
+		t.Errorf("zero placement ID: got %v", err)
+	}
+
+	// The failed calls leave the tree unchanged
+	if row, col, _ := tree.Position(3, 1); row != 0 || col != 0 {
+		t.Errorf("tree changed: %d, %d", row, col)
+	}
+}
+
+// TestPlacementTree_Depth tests the chain depth limit
+func TestPlacementTree_Depth(t *testing.T) {
+	tree := NewPlacementTree()
+	tree.Add(Placement{ImageID: 1, PlacementID: 1})
+	for i := uint32(2); i <= MaxRelativeDepth+1; i++ {
+		if err := tree.AddRelative(Placement{ImageID: i, PlacementID: 1}, i-1, 1, 1, 0); err != nil {
+			t.Fatalf("depth %d: %v", i-1, err)
+		}
+	}
+	if err := tree.AddRelative(Placement{ImageID: 100, PlacementID: 1}, MaxRelativeDepth+1, 1, 1, 0); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("expected ErrTooDeep, got %v", err)
+	}
+
+	// Moving a subtree under a deeper parent counts its height
+	other := NewPlacementTree()
+	other.Add(Placement{ImageID: 1, PlacementID: 1})
+	other.Add(Placement{ImageID: 50, PlacementID: 1})
+	for i := uint32(1); i <= 4; i++ {
+		if err := other.AddRelative(Placement{ImageID: i + 1, PlacementID: 1}, i, 1, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.AddRelative(Placement{ImageID: 50 + i, PlacementID: 1}, 50+i-1, 1, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.AddRelative(Placement{ImageID: 50, PlacementID: 1}, 5, 1, 0, 0); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("expected ErrTooDeep when moving a subtree, got %v", err)
+	}
+}
+
+// TestPlacementTree_Remove tests removing a subtree
+func TestPlacementTree_Remove(t *testing.T) {
+	tree := NewPlacementTree()
+	tree.Add(Placement{ImageID: 1, PlacementID: 1})
+	tree.AddRelative(Placement{ImageID: 2, PlacementID: 1}, 1, 1, 0, 0)
+	tree.AddRelative(Placement{ImageID: 3, PlacementID: 1}, 2, 1, 0, 0)
+
+	tree.Remove(2, 1)
+	for _, id := range []uint32{2, 3} {
+		if _, _, err := tree.Position(id, 1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("placement %d not removed", id)
+		}
+	}
+	if _, _, err := tree.Position(1, 1); err != nil {
+		t.Errorf("root removed: %v", err)
+	}
+	if err := tree.AddRelative(Placement{ImageID: 3, PlacementID: 1}, 2, 1, 0, 0); !errors.Is(err, ErrNoParent) {
+		t.Errorf("expected ErrNoParent, got %v", err)
+	}
+}
+
+// TestPlacementTree_Place tests the commands creating the tree
+func TestPlacementTree_Place(t *testing.T) {
+	tree := NewPlacementTree()
+	tree.Add(Placement{ImageID: 1, PlacementID: 1, Row: 2, Column: 3, Columns: 20})
+	tree.AddRelative(Placement{ImageID: 2, PlacementID: 4, ZIndex: 1}, 1, 1, 5, -1)
+
+	var buf bytes.Buffer
+	if err := tree.Place(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b7" +
+		"\x1b[3;4H\x1b_Ga=p,q=2,i=1,p=1,c=20,C=1\x1b\\" +
+		"\x1b_Ga=p,q=2,i=2,p=4,C=1,z=1,P=1,Q=1,H=5,V=-1\x1b\\" +
+		"\x1b8"
+	if buf.String() != want {
+		t.Errorf("Place:\n got %q\nwant %q", buf.String(), want)
+	}
+
+	d := NewDecoder(&buf)
+	for {
+		cmd, err := d.Decode()
+		if err != nil {
+			break
+		}
+		if err := cmd.Validate(); err != nil {
+			t.Errorf("invalid command %q: %v", cmd.Encode(), err)
+		}
+	}
+}
+
+// TestPlacementTree_Move tests moving a subtree by its root
+func TestPlacementTree_Move(t *testing.T) {
+	tree := NewPlacementTree()
+	tree.Add(Placement{ImageID: 1, PlacementID: 1, Row: 2, Column: 3})
+	tree.AddRelative(Placement{ImageID: 2, PlacementID: 1}, 1, 1, 5, 1)
+
+	var buf bytes.Buffer
+	if err := tree.Move(&buf, 1, 1, 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b7\x1b[11;21H\x1b_Ga=p,q=2,i=1,p=1,C=1\x1b\\\x1b8"
+	if buf.String() != want {
+		t.Errorf("Move wrote %q, want %q", buf.String(), want)
+	}
+	if row, col, _ := tree.Position(2, 1); row != 11 || col != 25 {
+		t.Errorf("child position = %d, %d; want 11, 25", row, col)
+	}
+
+	if err := tree.Move(&buf, 2, 1, 0, 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("moving a child: got %v", err)
+	}
+	if err := tree.Move(&buf, 9, 1, 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("moving an unknown placement: got %v", err)
+	}
+}
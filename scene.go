@@ -0,0 +1,182 @@
+package kgp
+
+import (
+	"cmp"
+	"errors"
+	"image"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// ErrInvalidPlacement indicates a placement a Scene cannot manage.
+var ErrInvalidPlacement = errors.New("placement requires non-zero image and placement IDs")
+
+// Placement is a placement of an image at a cell position in a Scene.
+type Placement struct {
+	ImageID     uint32
+	PlacementID uint32
+	Row         int // Zero-based cell row of the top-left corner
+	Column      int // Zero-based cell column of the top-left corner
+	// Columns and Rows are the display size in cells; zero lets the
+	// terminal derive it from the image size.
+	Columns, Rows int
+	// Source is the part of the image to display; empty displays all of it.
+	Source image.Rectangle
+	ZIndex int
+	// OffsetX and OffsetY are the pixel offset within the top-left cell.
+	OffsetX, OffsetY int
+}
+
+type placementKey struct {
+	imageID     uint32
+	placementID uint32
+}
+
+func (p Placement) key() placementKey {
+	return placementKey{p.ImageID, p.PlacementID}
+}
+
+// Scene holds the desired set of placements on screen. Flush sends only the
+// commands needed to get from the placements of the previous Flush to the
+// desired ones: a put for every new or changed placement, which replaces a
+// placement with the same IDs in place, and a delete for every removed one.
+// A Scene is not safe for concurrent use.
+type Scene struct {
+	desired map[placementKey]Placement
+	current map[placementKey]Placement
+}
+
+// NewScene creates an empty scene.
+func NewScene() *Scene {
+	return &Scene{
+		desired: make(map[placementKey]Placement),
+		current: make(map[placementKey]Placement),
+	}
+}
+
+// Set adds a placement, or replaces the placement with the same image and
+// placement IDs.
+func (s *Scene) Set(p Placement) error {
+	if p.ImageID == 0 || p.PlacementID == 0 {
+		return ErrInvalidPlacement
+	}
+	s.desired[p.key()] = p
+	return nil
+}
+
+// Remove removes a placement.
+func (s *Scene) Remove(imageID, placementID uint32) {
+	delete(s.desired, placementKey{imageID, placementID})
+}
+
+// Clear removes all placements.
+func (s *Scene) Clear() {
+	clear(s.desired)
+}
+
+// Placements returns the desired placements, ordered by image and placement ID.
+func (s *Scene) Placements() []Placement {
+	placements := make([]Placement, 0, len(s.desired))
+	for _, p := range s.desired {
+		placements = append(placements, p)
+	}
+	slices.SortFunc(placements, comparePlacements)
+	return placements
+}
+
+func comparePlacements(a, b Placement) int {
+	return cmp.Or(cmp.Compare(a.ImageID, b.ImageID), cmp.Compare(a.PlacementID, b.PlacementID))
+}
+
+// Reset forgets what is on screen, so the next Flush sends every placement,
+// for example after the screen was cleared.
+func (s *Scene) Reset() {
+	clear(s.current)
+}
+
+// Flush writes the commands that update the screen to the desired
+// placements in a single write. Each put is preceded by a cursor movement
+// to its cell; the cursor is saved before and restored after the update,
+// and puts do not move it. Commands are sent with responses suppressed.
+// Nothing is written if nothing changed.
+//
+// If the write fails, the screen state is unknown and the next Flush sends
+// the same changes again.
+func (s *Scene) Flush(w io.Writer) error {
+	buf := s.appendUpdate(nil)
+	if len(buf) == 0 {
+		return nil
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	clear(s.current)
+	for k, p := range s.desired {
+		s.current[k] = p
+	}
+	return nil
+}
+
+func (s *Scene) appendUpdate(buf []byte) []byte {
+	var removed, changed []Placement
+	for k, p := range s.current {
+		if _, ok := s.desired[k]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	for k, p := range s.desired {
+		if cur, ok := s.current[k]; !ok || cur != p {
+			changed = append(changed, p)
+		}
+	}
+	if len(removed) == 0 && len(changed) == 0 {
+		return buf
+	}
+	slices.SortFunc(removed, comparePlacements)
+	slices.SortFunc(changed, comparePlacements)
+
+	buf = append(buf, "\x1b7"...) // Save cursor
+	for _, p := range removed {
+		// d=i with both IDs set deletes just this placement
+		cmd := NewDelete(DeleteByImageID).
+			ImageID(p.ImageID).
+			PlacementID(p.PlacementID).
+			ResponseSuppression(ResponseOKOnly).
+			Build()
+		buf = cmd.AppendEncode(buf)
+	}
+	for _, p := range changed {
+		buf = appendCursorPosition(buf, p.Row, p.Column)
+		buf = p.command().AppendEncode(buf)
+	}
+	return append(buf, "\x1b8"...) // Restore cursor
+}
+
+// command returns the put command creating the placement.
+func (p Placement) command() *Command {
+	pb := NewPut(p.ImageID).
+		PlacementID(p.PlacementID).
+		CursorMovement(false).
+		ResponseSuppression(ResponseOKOnly)
+	if p.ZIndex != 0 {
+		pb.ZIndex(p.ZIndex)
+	}
+	Layout{
+		Columns: p.Columns,
+		Rows:    p.Rows,
+		OffsetX: p.OffsetX,
+		OffsetY: p.OffsetY,
+		Source:  p.Source,
+	}.apply(pb.cmd)
+	return pb.Build()
+}
+
+// appendCursorPosition appends a CUP sequence moving to a zero-based cell.
+func appendCursorPosition(buf []byte, row, column int) []byte {
+	buf = append(buf, "\x1b["...)
+	buf = strconv.AppendInt(buf, int64(row+1), 10)
+	buf = append(buf, ';')
+	buf = strconv.AppendInt(buf, int64(column+1), 10)
+	return append(buf, 'H')
+}
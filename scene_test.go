@@ -0,0 +1,150 @@
+package kgp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+// flushScene flushes s and returns the output
+func flushScene(t *testing.T, s *Scene) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.Flush(&buf); err != nil {
+		t.Fatalf("Flush error: %v", err)
+	}
+	return buf.String()
+}
+
+// TestScene_Flush tests the commands emitted for added, changed and removed placements
+func TestScene_Flush(t *testing.T) {
+	s := NewScene()
+	s.Set(Placement{ImageID: 1, PlacementID: 1, Row: 2, Column: 4, Columns: 10, Rows: 5})
+	s.Set(Placement{ImageID: 2, PlacementID: 1, ZIndex: -1, Source: image.Rect(0, 0, 8, 8), OffsetX: 3})
+
+	want := "\x1b7" +
+		"\x1b[3;5H\x1b_Ga=p,q=2,i=1,p=1,c=10,r=5,C=1\x1b\\" +
+		"\x1b[1;1H\x1b_Ga=p,q=2,i=2,p=1,x=0,y=0,w=8,h=8,X=3,Y=0,C=1,z=-1\x1b\\" +
+		"\x1b8"
+	if got := flushScene(t, s); got != want {
+		t.Errorf("first Flush:\n got %q\nwant %q", got, want)
+	}
+
+	if got := flushScene(t, s); got != "" {
+		t.Errorf("unchanged Flush wrote %q", got)
+	}
+
+	// Move one placement, remove the other, add a new one
+	s.Set(Placement{ImageID: 1, PlacementID: 1, Row: 3, Column: 4, Columns: 10, Rows: 5})
+	s.Remove(2, 1)
+	s.Set(Placement{ImageID: 3, PlacementID: 7, Row: 0, Column: 0})
+
+	want = "\x1b7" +
+		"\x1b_Ga=d,q=2,i=2,p=1,d=i\x1b\\" +
+		"\x1b[4;5H\x1b_Ga=p,q=2,i=1,p=1,c=10,r=5,C=1\x1b\\" +
+		"\x1b[1;1H\x1b_Ga=p,q=2,i=3,p=7,C=1\x1b\\" +
+		"\x1b8"
+	if got := flushScene(t, s); got != want {
+		t.Errorf("second Flush:\n got %q\nwant %q", got, want)
+	}
+}
+
+// TestScene_Commands tests that emitted commands are valid
+func TestScene_Commands(t *testing.T) {
+	s := NewScene()
+	s.Set(Placement{ImageID: 1, PlacementID: 1, Columns: 10, Source: image.Rect(1, 2, 3, 4), ZIndex: 5, OffsetY: 2})
+	s.Set(Placement{ImageID: 2, PlacementID: 9})
+	flushScene(t, s)
+	s.Clear()
+
+	var buf bytes.Buffer
+	s.Set(Placement{ImageID: 3, PlacementID: 1})
+	if err := s.Flush(&buf); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(&buf)
+	count := 0
+	for {
+		cmd, err := d.Decode()
+		if err != nil {
+			break
+		}
+		count++
+		if err := cmd.Validate(); err != nil {
+			t.Errorf("invalid command %q: %v", cmd.Encode(), err)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected 2 deletes and 1 put, got %d commands", count)
+	}
+}
+
+// TestScene_RemoveDeletesPlacement tests that a removal deletes only that placement by ID
+func TestScene_RemoveDeletesPlacement(t *testing.T) {
+	s := NewScene()
+	s.Set(Placement{ImageID: 5, PlacementID: 2})
+	flushScene(t, s)
+	s.Remove(5, 2)
+
+	var buf bytes.Buffer
+	if err := s.Flush(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := NewDecoder(&buf).Decode()
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	want := map[string]string{"a": "d", "d": "i", "i": "5", "p": "2"}
+	for k, v := range want {
+		if got, _ := cmd.Key(k); got != v {
+			t.Errorf("key %s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+// TestScene_Reset tests resending everything after Reset
+func TestScene_Reset(t *testing.T) {
+	s := NewScene()
+	s.Set(Placement{ImageID: 1, PlacementID: 1})
+	first := flushScene(t, s)
+
+	s.Reset()
+	if got := flushScene(t, s); got != first {
+		t.Errorf("Flush after Reset = %q, want %q", got, first)
+	}
+}
+
+// TestScene_FlushError tests that failed writes are retried
+func TestScene_FlushError(t *testing.T) {
+	s := NewScene()
+	s.Set(Placement{ImageID: 1, PlacementID: 1})
+
+	if err := s.Flush(failingWriter{}); err == nil {
+		t.Fatal("expected write error")
+	}
+	if got := flushScene(t, s); got == "" {
+		t.Error("changes not resent after failed write")
+	}
+}
+
+// TestScene_Set tests placement validation and ordering
+func TestScene_Set(t *testing.T) {
+	s := NewScene()
+	if err := s.Set(Placement{ImageID: 1}); !errors.Is(err, ErrInvalidPlacement) {
+		t.Errorf("expected ErrInvalidPlacement, got %v", err)
+	}
+	if err := s.Set(Placement{PlacementID: 1}); !errors.Is(err, ErrInvalidPlacement) {
+		t.Errorf("expected ErrInvalidPlacement, got %v", err)
+	}
+
+	s.Set(Placement{ImageID: 2, PlacementID: 1})
+	s.Set(Placement{ImageID: 1, PlacementID: 2})
+	s.Set(Placement{ImageID: 1, PlacementID: 1, Row: 1})
+	s.Set(Placement{ImageID: 1, PlacementID: 1, Row: 5})
+
+	got := s.Placements()
+	if len(got) != 3 || got[0].PlacementID != 1 || got[0].Row != 5 || got[1].PlacementID != 2 || got[2].ImageID != 2 {
+		t.Errorf("Placements() = %+v", got)
+	}
+}
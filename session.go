@@ -0,0 +1,224 @@
+package kgp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Session sends commands to a terminal and waits for the correlated
+// responses. It writes commands to an io.Writer and reads responses from an
+// io.Reader, typically both ends of the tty. Other input on the reader is
+// discarded.
+//
+// A Session starts a goroutine that receives responses until the reader
+// returns an error or the response channel is closed. It is safe for
+// concurrent use.
+type Session struct {
+	w   io.Writer
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	waiters []*waiter
+	next    uint32
+	readErr error
+	done    chan struct{}
+}
+
+// waiter is a pending Send waiting for its response.
+type waiter struct {
+	imageID     uint32
+	imageNumber uint32
+	placementID uint32
+	ch          chan *Response
+}
+
+func (w *waiter) matches(resp *Response) bool {
+	if w.imageNumber != 0 {
+		return resp.ImageNumber == w.imageNumber
+	}
+	return resp.ImageID == w.imageID && (w.placementID == 0 || resp.PlacementID == w.placementID)
+}
+
+// NewSession creates a session writing commands to w and reading responses from r.
+func NewSession(w io.Writer, r io.Reader) *Session {
+	s := newSession(w)
+	go s.readLoop(r)
+	return s
+}
+
+// NewSessionWithResponses creates a session writing commands to w and
+// receiving responses from a channel, such as InputDemux.Responses. Use it
+// when the tty input is also consumed as keyboard input. The session stops
+// waiting for responses when the channel is closed.
+func NewSessionWithResponses(w io.Writer, responses <-chan *Response) *Session {
+	s := newSession(w)
+	go s.receiveLoop(responses)
+	return s
+}
+
+func newSession(w io.Writer) *Session {
+	return &Session{
+		w:    w,
+		next: 1,
+		done: make(chan struct{}),
+	}
+}
+
+// Send writes cmd to the terminal and waits for its response. The returned
+// error is the response's Err for error responses, ctx.Err() if the context
+// ends first, or the read error if the reader fails.
+//
+// A command without an image ID or image number is sent with an
+// automatically assigned correlation value: an image ID for queries and an
+// image number otherwise. The command passed in is not modified.
+//
+// Send returns a nil Response and nil error as soon as the command is written
+// if no response is expected: for delete commands, and for commands whose
+// ResponseSuppression is not ResponseAll.
+func (s *Session) Send(ctx context.Context, cmd *Command) (*Response, error) {
+	if !expectsResponse(cmd) {
+		return nil, s.write(cmd)
+	}
+
+	cmd = cmd.clone()
+	w := &waiter{ch: make(chan *Response, 1)}
+
+	s.mu.Lock()
+	if s.readErr != nil {
+		err := s.readErr
+		s.mu.Unlock()
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	switch {
+	case parseKeyUint32(cmd, "I") != 0:
+		w.imageNumber = parseKeyUint32(cmd, "I")
+	case parseKeyUint32(cmd, "i") != 0:
+		w.imageID = parseKeyUint32(cmd, "i")
+		w.placementID = parseKeyUint32(cmd, "p")
+	case cmd.Action() == ActionQuery:
+		w.imageID = s.nextCorrelation()
+		cmd.SetKeyUint32("i", w.imageID)
+	default:
+		w.imageNumber = s.nextCorrelation()
+		cmd.SetKeyUint32("I", w.imageNumber)
+	}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	if err := s.write(cmd); err != nil {
+		s.removeWaiter(w)
+		return nil, err
+	}
+
+	select {
+	case resp := <-w.ch:
+		return resp, resp.Err()
+	case <-ctx.Done():
+		s.removeWaiter(w)
+		return nil, ctx.Err()
+	case <-s.done:
+		s.removeWaiter(w)
+		// A response may have been dispatched just before the reader failed
+		select {
+		case resp := <-w.ch:
+			return resp, resp.Err()
+		default:
+		}
+		return nil, fmt.Errorf("read response: %w", s.readErr)
+	}
+}
+
+// expectsResponse reports whether the terminal replies to cmd.
+func expectsResponse(cmd *Command) bool {
+	if q, ok := cmd.Key("q"); ok && q != "0" {
+		return false
+	}
+	return cmd.Action() != ActionDelete
+}
+
+func parseKeyUint32(cmd *Command, key string) uint32 {
+	v, _ := strconv.ParseUint(cmd.controlData[key], 10, 32)
+	return uint32(v)
+}
+
+// nextCorrelation returns the next correlation value. s.mu must be held.
+func (s *Session) nextCorrelation() uint32 {
+	v := s.next
+	s.next++
+	if s.next == 0 {
+		s.next = 1
+	}
+	return v
+}
+
+// write sends cmd, chunking its payload as required.
+func (s *Session) write(cmd *Command) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	cw := NewChunkWriter(s.w, cmd)
+	if _, err := cw.Write(cmd.payload); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+func (s *Session) removeWaiter(w *waiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.waiters {
+		if other == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch delivers resp to the oldest waiter it matches. Responses nobody
+// is waiting for are dropped.
+func (s *Session) dispatch(resp *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.waiters {
+		if w.matches(resp) {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			w.ch <- resp
+			return
+		}
+	}
+}
+
+// stop fails all current and future waits with err.
+func (s *Session) stop(err error) {
+	s.mu.Lock()
+	s.readErr = err
+	s.mu.Unlock()
+	close(s.done)
+}
+
+func (s *Session) receiveLoop(responses <-chan *Response) {
+	for resp := range responses {
+		s.dispatch(resp)
+	}
+	s.stop(io.EOF)
+}
+
+func (s *Session) readLoop(r io.Reader) {
+	sr := newSequenceReader(r)
+	for {
+		body, err := sr.next()
+		if err != nil {
+			s.stop(err)
+			return
+		}
+
+		resp, err := parseResponse(string(body), false)
+		if err != nil {
+			continue
+		}
+		s.dispatch(resp)
+	}
+}
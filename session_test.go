@@ -0,0 +1,181 @@
+package kgp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTerminal decodes commands written to a session and replies using respond.
+// A nil reply sends nothing.
+func fakeTerminal(t *testing.T, respond func(cmd *Command) string) *Session {
+	t.Helper()
+	cmdR, cmdW := io.Pipe()
+	respR, respW := io.Pipe()
+	t.Cleanup(func() {
+		cmdW.Close()
+		respW.Close()
+	})
+
+	go func() {
+		d := NewDecoder(cmdR)
+		for {
+			cmd, err := d.Decode()
+			if err != nil {
+				respW.CloseWithError(err)
+				return
+			}
+			if reply := respond(cmd); reply != "" {
+				// Interleave unrelated input to exercise filtering
+				io.WriteString(respW, "typed\x1b[A")
+				io.WriteString(respW, reply)
+			}
+		}
+	}()
+
+	return NewSession(cmdW, respR)
+}
+
+// echoReply builds an OK reply echoing the correlation keys of cmd
+func echoReply(cmd *Command) string {
+	var keys []string
+	for _, k := range []string{"i", "I", "p"} {
+		if v, ok := cmd.Key(k); ok {
+			keys = append(keys, k+"="+v)
+		}
+	}
+	return "\x1b_G" + strings.Join(keys, ",") + ";OK\x1b\\"
+}
+
+// TestSessionSend tests sending a command and receiving its response
+func TestSessionSend(t *testing.T) {
+	s := fakeTerminal(t, echoReply)
+
+	resp, err := s.Send(context.Background(), NewPut(10).PlacementID(2).Build())
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if !resp.Success || resp.ImageID != 10 || resp.PlacementID != 2 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+// TestSessionAutoCorrelation tests automatic assignment of correlation values
+func TestSessionAutoCorrelation(t *testing.T) {
+	var seen []*Command
+	s := fakeTerminal(t, func(cmd *Command) string {
+		seen = append(seen, cmd)
+		return echoReply(cmd)
+	})
+
+	query := QuerySupport()
+	resp, err := s.Send(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if resp.ImageID == 0 {
+		t.Error("query should be correlated by an assigned image ID")
+	}
+	if _, ok := query.Key("i"); ok {
+		t.Error("Send should not modify the caller's command")
+	}
+
+	transmit := NewTransmit().Format(FormatPNG).TransmitDirect([]byte("png")).Build()
+	resp, err = s.Send(context.Background(), transmit)
+	if err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if resp.ImageNumber == 0 {
+		t.Error("transmit should be correlated by an assigned image number")
+	}
+	if _, ok := seen[1].Key("I"); !ok {
+		t.Error("transmit was not sent with an image number")
+	}
+}
+
+// TestSessionErrorResponse tests that error responses are returned as errors
+func TestSessionErrorResponse(t *testing.T) {
+	s := fakeTerminal(t, func(cmd *Command) string {
+		id, _ := cmd.Key("i")
+		return fmt.Sprintf("\x1b_Gi=%s;ENOENT:no such image\x1b\\", id)
+	})
+
+	resp, err := s.Send(context.Background(), NewPut(7).Build())
+	if !IsNotFound(err) {
+		t.Fatalf("expected ENOENT error, got %v", err)
+	}
+	if resp == nil || resp.ImageID != 7 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+// TestSessionNoResponseExpected tests commands that never get a reply
+func TestSessionNoResponseExpected(t *testing.T) {
+	s := fakeTerminal(t, func(cmd *Command) string { return "" })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, cmd := range []*Command{
+		DeleteAll(),
+		NewPut(1).ResponseSuppression(ResponseErrorsOnly).Build(),
+	} {
+		resp, err := s.Send(ctx, cmd)
+		if resp != nil || err != nil {
+			t.Errorf("Send(%q) = %v, %v; want nil, nil", cmd.Encode(), resp, err)
+		}
+	}
+}
+
+// TestSessionContextDeadline tests that Send honours context deadlines
+func TestSessionContextDeadline(t *testing.T) {
+	s := fakeTerminal(t, func(cmd *Command) string { return "" })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := s.Send(ctx, NewPut(1).Build())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) != 0 {
+		t.Error("waiter should be removed after cancellation")
+	}
+}
+
+// TestSessionConcurrentSends tests that concurrent sends get their own responses
+func TestSessionConcurrentSends(t *testing.T) {
+	s := fakeTerminal(t, echoReply)
+
+	errs := make(chan error, 10)
+	for i := 1; i <= 10; i++ {
+		go func(id uint32) {
+			resp, err := s.Send(context.Background(), NewPut(id).Build())
+			if err == nil && resp.ImageID != id {
+				err = fmt.Errorf("image %d got response for %d", id, resp.ImageID)
+			}
+			errs <- err
+		}(uint32(i))
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestSessionReaderClosed tests that pending sends fail when the reader ends
+func TestSessionReaderClosed(t *testing.T) {
+	respR, respW := io.Pipe()
+	s := NewSession(io.Discard, respR)
+	respW.Close()
+
+	_, err := s.Send(context.Background(), NewPut(1).Build())
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
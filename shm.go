@@ -0,0 +1,105 @@
+package kgp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrSharedMemoryUnsupported indicates POSIX shared memory is not available
+// on this platform.
+var ErrSharedMemoryUnsupported = errors.New("shared memory is not supported on this platform")
+
+// SharedMemory is a POSIX shared memory object holding image data for
+// transmission with t=s. The terminal unlinks the object after reading it;
+// SharedMemory unlinks it when the terminal does not.
+type SharedMemory struct {
+	name string
+	size int
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	unlinked bool
+}
+
+// TransmitSharedMemoryData creates a uniquely named shared memory object
+// readable only by the current user, fills it with data and sets it as the
+// data source. The object is unlinked after timeout unless Unlink is called
+// first; a timeout of zero disables this.
+//
+// Pass the result of sending the command to Done on the returned object, so
+// the object does not outlive a failed transmission. Objects the terminal
+// read successfully are already gone, which Unlink ignores.
+func (tb *TransmitBuilder) TransmitSharedMemoryData(data []byte, timeout time.Duration) (*TransmitBuilder, *SharedMemory, error) {
+	shm, err := newSharedMemory(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout > 0 {
+		shm.mu.Lock()
+		shm.timer = time.AfterFunc(timeout, func() { shm.Unlink() })
+		shm.mu.Unlock()
+	}
+	return tb.TransmitSharedMemory(shm.name, shm.size), shm, nil
+}
+
+func newSharedMemory(data []byte) (*SharedMemory, error) {
+	for range 10 {
+		name := fmt.Sprintf("/kgp-%d-%08x", os.Getpid(), rand.Uint32())
+		err := createSharedMemory(name, data)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create shared memory: %w", err)
+		}
+		return &SharedMemory{name: name, size: len(data)}, nil
+	}
+	return nil, errors.New("create shared memory: no unique name found")
+}
+
+// Name returns the name of the object, as sent in the payload.
+func (s *SharedMemory) Name() string {
+	return s.name
+}
+
+// Size returns the size of the data in bytes.
+func (s *SharedMemory) Size() int {
+	return s.size
+}
+
+// Done unlinks the object if err, the result of sending its command, is
+// non-nil: the terminal reported an error, so it will not remove the object
+// itself, or the caller gave up waiting. A nil err leaves the object to the
+// terminal, and to the timeout in case it was not read.
+//
+//	resp, err := session.Send(ctx, tb.Build())
+//	shm.Done(err)
+func (s *SharedMemory) Done(err error) error {
+	if err == nil {
+		return nil
+	}
+	return s.Unlink()
+}
+
+// Unlink removes the object unless it was already removed, by the terminal
+// or an earlier call, and stops the timeout. It is safe to call more than once.
+func (s *SharedMemory) Unlink() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unlinked {
+		return nil
+	}
+	s.unlinked = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if err := unlinkSharedMemory(s.name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
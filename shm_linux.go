@@ -0,0 +1,31 @@
+//go:build linux
+
+package kgp
+
+import (
+	"os"
+	"strings"
+)
+
+// shmDir is where Linux exposes POSIX shared memory objects.
+const shmDir = "/dev/shm/"
+
+// createSharedMemory creates the POSIX shared memory object name, which must
+// start with a slash, and fills it with data.
+func createSharedMemory(name string, data []byte) error {
+	f, err := os.OpenFile(shmDir+strings.TrimPrefix(name, "/"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		unlinkSharedMemory(name)
+		return err
+	}
+	return f.Close()
+}
+
+// unlinkSharedMemory removes the POSIX shared memory object name.
+func unlinkSharedMemory(name string) error {
+	return os.Remove(shmDir + strings.TrimPrefix(name, "/"))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package kgp
+
+func createSharedMemory(name string, data []byte) error {
+	return ErrSharedMemoryUnsupported
+}
+
+func unlinkSharedMemory(name string) error {
+	return ErrSharedMemoryUnsupported
+}
@@ -0,0 +1,172 @@
+package kgp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func shmPath(name string) string {
+	return "/dev/shm/" + strings.TrimPrefix(name, "/")
+}
+
+// TestTransmitSharedMemoryData tests creating the shared memory object
+func TestTransmitSharedMemoryData(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+	tb, shm, err := NewTransmit().ImageID(1).Format(FormatRGBA).Dimensions(2, 2).TransmitSharedMemoryData(data, 0)
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, ErrSharedMemoryUnsupported) {
+			t.Errorf("expected ErrSharedMemoryUnsupported, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("TransmitSharedMemoryData error: %v", err)
+	}
+	defer shm.Unlink()
+
+	cmd := tb.Build()
+	if cmd.controlData["t"] != "s" || cmd.controlData["S"] != "16" {
+		t.Errorf("unexpected control data %v", cmd.controlData)
+	}
+	if string(cmd.payload) != shm.Name() || shm.Size() != len(data) {
+		t.Errorf("payload %q, name %q, size %d", cmd.payload, shm.Name(), shm.Size())
+	}
+	if err := cmd.Validate(); err != nil {
+		t.Errorf("Validate error: %v", err)
+	}
+
+	got, err := os.ReadFile(shmPath(shm.Name()))
+	if err != nil {
+		t.Fatalf("read shared memory: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("shared memory content mismatch")
+	}
+	info, err := os.Stat(shmPath(shm.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("permissions = %v, want 0600", perm)
+	}
+
+	if err := shm.Unlink(); err != nil {
+		t.Errorf("Unlink error: %v", err)
+	}
+	if _, err := os.Stat(shmPath(shm.Name())); !os.IsNotExist(err) {
+		t.Error("shared memory not removed")
+	}
+	if err := shm.Unlink(); err != nil {
+		t.Errorf("second Unlink error: %v", err)
+	}
+}
+
+// TestSharedMemory_UnlinkAfterTerminal tests Unlink when the terminal already removed the object
+func TestSharedMemory_UnlinkAfterTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared memory requires Linux")
+	}
+	_, shm, err := NewTransmit().TransmitSharedMemoryData([]byte{1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(shmPath(shm.Name())); err != nil {
+		t.Fatal(err)
+	}
+	if err := shm.Unlink(); err != nil {
+		t.Errorf("Unlink error: %v", err)
+	}
+}
+
+// TestSharedMemory_Timeout tests removal when the terminal never reads the object
+func TestSharedMemory_Timeout(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared memory requires Linux")
+	}
+	_, shm, err := NewTransmit().TransmitSharedMemoryData([]byte{1}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shm.Unlink()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(shmPath(shm.Name())); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("shared memory not removed after timeout")
+}
+
+// TestSharedMemory_DoneOnError tests unlinking when the terminal reports an error
+func TestSharedMemory_DoneOnError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared memory requires Linux")
+	}
+	s := fakeTerminal(t, func(cmd *Command) string {
+		id, _ := cmd.Key("i")
+		return "\x1b_Gi=" + id + ";EBADF:cannot read shared memory\x1b\\"
+	})
+	tb, shm, err := NewTransmit().ImageID(4).Format(FormatRGBA).Dimensions(1, 1).
+		TransmitSharedMemoryData([]byte{1, 2, 3, 4}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shm.Unlink()
+
+	_, err = s.Send(context.Background(), tb.Build())
+	if !errors.Is(err, ErrBadFile) {
+		t.Fatalf("expected EBADF, got %v", err)
+	}
+	if err := shm.Done(err); err != nil {
+		t.Errorf("Done error: %v", err)
+	}
+	if _, err := os.Stat(shmPath(shm.Name())); !os.IsNotExist(err) {
+		t.Error("shared memory not removed after an error response")
+	}
+}
+
+// TestSharedMemory_DoneOnSuccess tests leaving the object to the terminal on success
+func TestSharedMemory_DoneOnSuccess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared memory requires Linux")
+	}
+	_, shm, err := NewTransmit().TransmitSharedMemoryData([]byte{1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shm.Unlink()
+
+	if err := shm.Done(nil); err != nil {
+		t.Errorf("Done error: %v", err)
+	}
+	if _, err := os.Stat(shmPath(shm.Name())); err != nil {
+		t.Errorf("shared memory removed on success: %v", err)
+	}
+}
+
+// TestSharedMemory_UniqueNames tests that objects get distinct names
+func TestSharedMemory_UniqueNames(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("shared memory requires Linux")
+	}
+	seen := make(map[string]bool)
+	for range 20 {
+		_, shm, err := NewTransmit().TransmitSharedMemoryData([]byte{1}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shm.Unlink()
+		if seen[shm.Name()] {
+			t.Fatalf("duplicate name %q", shm.Name())
+		}
+		seen[shm.Name()] = true
+	}
+}
@@ -0,0 +1,106 @@
+package kgp
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// ErrChunkWriterClosed indicates a write to a ChunkWriter after Close.
+var ErrChunkWriterClosed = errors.New("chunk writer is closed")
+
+// chunkRawSize is the number of payload bytes that encode to exactly
+// MaxChunkSize base64 bytes.
+const chunkRawSize = MaxChunkSize / 4 * 3
+
+// ChunkWriter streams a command payload to an underlying writer as a chunked
+// transmission. Payload bytes written to it are base64-encoded in
+// MaxChunkSize chunks and emitted as complete escape sequences as soon as
+// they are known not to be the final chunk. Close must be called to emit the
+// final chunk with m=0.
+//
+// Only the control data of the command is used; its payload is ignored.
+type ChunkWriter struct {
+	w       io.Writer
+	cmd     *Command
+	buf     []byte
+	out     []byte
+	started bool
+	closed  bool
+	n       int64
+	err     error
+}
+
+// NewChunkWriter creates a ChunkWriter that writes chunks of cmd to w.
+func NewChunkWriter(w io.Writer, cmd *Command) *ChunkWriter {
+	return &ChunkWriter{
+		w:   w,
+		cmd: cmd,
+		buf: make([]byte, 0, chunkRawSize),
+	}
+}
+
+// Write buffers payload data and emits every chunk that is complete and
+// followed by more data.
+func (cw *ChunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, ErrChunkWriterClosed
+	}
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(cw.buf) == chunkRawSize {
+			// More data follows, so the pending chunk is not the last one.
+			if err := cw.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(cw.buf[len(cw.buf):chunkRawSize], p)
+		cw.buf = cw.buf[:len(cw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close emits the final chunk. A command with no payload is written as a
+// single escape sequence without the m key.
+func (cw *ChunkWriter) Close() error {
+	if cw.closed {
+		return cw.err
+	}
+	cw.closed = true
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.flush(true)
+}
+
+// Written returns the number of bytes written to the underlying writer.
+func (cw *ChunkWriter) Written() int64 {
+	return cw.n
+}
+
+func (cw *ChunkWriter) flush(last bool) error {
+	out := cw.cmd.appendChunkHeader(cw.out[:0], !cw.started, last)
+	if len(cw.buf) > 0 {
+		out = append(out, ';')
+		out = base64.StdEncoding.AppendEncode(out, cw.buf)
+	}
+	out = append(out, "\x1b\\"...)
+
+	cw.out = out
+	cw.started = true
+	cw.buf = cw.buf[:0]
+
+	n, err := cw.w.Write(out)
+	cw.n += int64(n)
+	if err != nil {
+		cw.err = err
+	}
+	return err
+}
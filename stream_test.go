@@ -0,0 +1,147 @@
+package kgp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// splitSequences splits concatenated escape sequences into individual sequences
+func splitSequences(s string) []string {
+	var seqs []string
+	for _, part := range strings.SplitAfter(s, "\x1b\\") {
+		if part != "" {
+			seqs = append(seqs, part)
+		}
+	}
+	return seqs
+}
+
+// TestChunkWriter tests streaming a payload through a ChunkWriter
+func TestChunkWriter(t *testing.T) {
+	payload := make([]byte, 10000)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	cmd := NewTransmit().ImageID(5).Format(FormatRGBA).Build()
+
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf, cmd)
+	// Write in odd-sized pieces to exercise buffering across chunk boundaries
+	for i := 0; i < len(payload); i += 777 {
+		end := min(i+777, len(payload))
+		if _, err := cw.Write(payload[i:end]); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if cw.Written() != int64(buf.Len()) {
+		t.Errorf("Written() = %d, want %d", cw.Written(), buf.Len())
+	}
+
+	cmd.SetPayload(payload)
+	want := strings.Join(cmd.EncodeChunked(MaxChunkSize), "")
+	if buf.String() != want {
+		t.Error("ChunkWriter output differs from EncodeChunked output")
+	}
+}
+
+// TestChunkWriterFrame tests that frame data chunks repeat a=f
+func TestChunkWriterFrame(t *testing.T) {
+	cmd := NewFrame(5).Format(FormatRGBA).Dimensions(40, 40).Build()
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf, cmd)
+	if _, err := cw.Write(make([]byte, 6400)); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	seqs := splitSequences(buf.String())
+	if len(seqs) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(seqs))
+	}
+	for _, seq := range seqs[1:] {
+		if !strings.HasPrefix(seq, "\x1b_Ga=f,i=5,") {
+			t.Errorf("continuation chunk without a=f: %q", seq[:20])
+		}
+	}
+}
+
+// TestChunkWriterExactChunk tests that a payload filling exactly one chunk is not split
+func TestChunkWriterExactChunk(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf, NewTransmit().Build())
+	cw.Write(make([]byte, chunkRawSize))
+	cw.Close()
+
+	seqs := splitSequences(buf.String())
+	if len(seqs) != 1 {
+		t.Fatalf("expected 1 sequence, got %d", len(seqs))
+	}
+	if strings.Contains(seqs[0], "m=") {
+		t.Error("single chunk should not contain m key")
+	}
+}
+
+// TestChunkWriterEmpty tests closing a ChunkWriter without payload
+func TestChunkWriterEmpty(t *testing.T) {
+	cmd := NewPut(1).Build()
+
+	var buf bytes.Buffer
+	cw := NewChunkWriter(&buf, cmd)
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if buf.String() != cmd.Encode() {
+		t.Errorf("got %q, want %q", buf.String(), cmd.Encode())
+	}
+}
+
+// TestChunkWriterClosed tests writing after Close
+func TestChunkWriterClosed(t *testing.T) {
+	cw := NewChunkWriter(&bytes.Buffer{}, NewTransmit().Build())
+	cw.Close()
+	if _, err := cw.Write([]byte("x")); !errors.Is(err, ErrChunkWriterClosed) {
+		t.Errorf("expected ErrChunkWriterClosed, got %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// TestChunkWriterWriteError tests that underlying write errors are reported
+func TestChunkWriterWriteError(t *testing.T) {
+	cw := NewChunkWriter(failingWriter{}, NewTransmit().Build())
+	if _, err := cw.Write(make([]byte, chunkRawSize+1)); err == nil {
+		t.Fatal("expected write error")
+	}
+	if err := cw.Close(); err == nil {
+		t.Fatal("expected Close to report the earlier write error")
+	}
+}
+
+// decodeChunkedPayload joins and decodes the payload of a chunked transmission
+func decodeChunkedPayload(t *testing.T, s string) []byte {
+	t.Helper()
+	var encoded strings.Builder
+	for _, seq := range splitSequences(s) {
+		body := strings.TrimSuffix(strings.TrimPrefix(seq, "\x1b_G"), "\x1b\\")
+		if _, data, ok := strings.Cut(body, ";"); ok {
+			encoded.WriteString(data)
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		t.Fatalf("invalid base64 payload: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,156 @@
+package kgp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TempFiles tracks the temporary files written by TransmitTempData and
+// TransmitTempReader.
+var TempFiles = &TempFileTracker{}
+
+// TempFileTracker records temporary files written for transmission, so
+// files the terminal did not consume can be removed. The terminal deletes
+// temporary files after reading them; files it already deleted are
+// forgotten silently. It is safe for concurrent use.
+type TempFileTracker struct {
+	mu    sync.Mutex
+	files map[string]time.Time
+}
+
+// Add records a file created at the current time.
+func (t *TempFileTracker) Add(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files == nil {
+		t.files = make(map[string]time.Time)
+	}
+	t.files[path] = time.Now()
+}
+
+// Remove removes a recorded file, for example after the terminal reported
+// an error for its transmission.
+func (t *TempFileTracker) Remove(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, path)
+	return removeTempFile(path)
+}
+
+// RemoveOlderThan removes the recorded files created more than age ago,
+// which the terminal should have consumed by now.
+func (t *TempFileTracker) RemoveOlderThan(age time.Duration) error {
+	return t.removeIf(func(created time.Time) bool { return time.Since(created) > age })
+}
+
+// RemoveAll removes all recorded files. Call it before exiting.
+func (t *TempFileTracker) RemoveAll() error {
+	return t.removeIf(func(time.Time) bool { return true })
+}
+
+func (t *TempFileTracker) removeIf(match func(created time.Time) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []error
+	for path, created := range t.files {
+		if !match(created) {
+			continue
+		}
+		delete(t.files, path)
+		if err := removeTempFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Pending returns the recorded files that still exist, sorted by path.
+func (t *TempFileTracker) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var paths []string
+	for path := range t.files {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			delete(t.files, path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func removeTempFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// TransmitTempData writes data to a new file in the system temporary
+// directory whose name contains "tty-graphics-protocol", flushes it to disk
+// and sets it as a temporary file data source (t=t). When Compress is set,
+// the data is zlib-compressed as it is written. The file is recorded in
+// TempFiles.
+func (tb *TransmitBuilder) TransmitTempData(data []byte) (*TransmitBuilder, error) {
+	return tb.TransmitTempReader(bytes.NewReader(data))
+}
+
+// TransmitTempReader is like TransmitTempData but copies the data from r.
+func (tb *TransmitBuilder) TransmitTempReader(r io.Reader) (*TransmitBuilder, error) {
+	compress := tb.compression == CompressionZlib
+	path, err := writeTempFile(r, compress)
+	if err != nil {
+		return nil, err
+	}
+	TempFiles.Add(path)
+	if compress {
+		tb.cmd.SetKey("o", string(CompressionZlib))
+	}
+	if _, err := tb.TryTransmitTemp(path); err != nil {
+		return nil, err
+	}
+	tb.tempUncompressed = !compress
+	return tb, nil
+}
+
+func writeTempFile(r io.Reader, compress bool) (path string, err error) {
+	f, err := os.CreateTemp("", "tty-graphics-protocol-*")
+	if err != nil {
+		return "", fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if compress {
+		zw := zlib.NewWriter(f)
+		if _, err := io.Copy(zw, r); err != nil {
+			return "", fmt.Errorf("write temporary file: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return "", fmt.Errorf("write temporary file: %w", err)
+		}
+	} else if _, err := io.Copy(f, r); err != nil {
+		return "", fmt.Errorf("write temporary file: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("sync temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("close temporary file: %w", err)
+	}
+	return f.Name(), nil
+}
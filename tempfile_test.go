@@ -0,0 +1,160 @@
+package kgp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTransmitTempData tests writing data to a temporary file
+func TestTransmitTempData(t *testing.T) {
+	data := []byte("image data")
+	tb, err := NewTransmit().ImageID(1).Format(FormatPNG).TransmitTempData(data)
+	if err != nil {
+		t.Fatalf("TransmitTempData error: %v", err)
+	}
+	cmd := tb.Build()
+	path := string(cmd.payload)
+	defer TempFiles.Remove(path)
+
+	if cmd.controlData["t"] != "t" {
+		t.Errorf("expected t=t, got %q", cmd.controlData["t"])
+	}
+	if !strings.Contains(filepath.Base(path), "tty-graphics-protocol") {
+		t.Errorf("path %q does not contain tty-graphics-protocol", path)
+	}
+	if filepath.Dir(path) != filepath.Clean(os.TempDir()) {
+		t.Errorf("path %q is not in the temporary directory", path)
+	}
+	if err := cmd.Validate(); err != nil {
+		t.Errorf("Validate error: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("file content = %q", got)
+	}
+	if !slices.Contains(TempFiles.Pending(), path) {
+		t.Error("file not tracked")
+	}
+}
+
+// TestTransmitTempReader_Compress tests compressing data written from a reader
+func TestTransmitTempReader_Compress(t *testing.T) {
+	data := bytes.Repeat([]byte{7}, 10000)
+	tb, err := NewTransmit().Format(FormatRGBA).Dimensions(50, 50).Compress().TransmitTempReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("TransmitTempReader error: %v", err)
+	}
+	cmd := tb.Build()
+	path := string(cmd.payload)
+	defer TempFiles.Remove(path)
+
+	if cmd.controlData["o"] != "z" {
+		t.Error("expected o=z")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("decompressed content mismatch")
+	}
+}
+
+// TestTransmitTempData_CompressAfter tests that BuildChecked rejects
+// compression enabled after the file was written uncompressed
+func TestTransmitTempData_CompressAfter(t *testing.T) {
+	tb, err := NewTransmit().Format(FormatPNG).TransmitTempData([]byte("png"))
+	if err != nil {
+		t.Fatalf("TransmitTempData error: %v", err)
+	}
+	defer TempFiles.Remove(string(tb.Build().payload))
+
+	if _, err := tb.Compress().BuildChecked(); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("BuildChecked error = %v, want ErrInvalidCommand", err)
+	}
+}
+
+// TestTransmitTempData_Replaced tests that compression may be enabled once
+// the temporary file is replaced by another data source
+func TestTransmitTempData_Replaced(t *testing.T) {
+	tb, err := NewTransmit().Format(FormatPNG).TransmitTempData([]byte("png"))
+	if err != nil {
+		t.Fatalf("TransmitTempData error: %v", err)
+	}
+	defer TempFiles.Remove(string(tb.Build().payload))
+
+	if _, err := tb.TransmitDirect([]byte("data")).Compress().BuildChecked(); err != nil {
+		t.Errorf("BuildChecked error: %v", err)
+	}
+}
+
+// TestTempFileTracker tests removing leftover files
+func TestTempFileTracker(t *testing.T) {
+	dir := t.TempDir()
+	var tracker TempFileTracker
+
+	newFile := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		tracker.Add(path)
+		return path
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	a := newFile("a")
+	b := newFile("b")
+	consumed := newFile("consumed")
+	os.Remove(consumed)
+
+	if got := tracker.Pending(); !slices.Equal(got, []string{a, b}) {
+		t.Errorf("Pending() = %v", got)
+	}
+
+	if err := tracker.Remove(a); err != nil || exists(a) {
+		t.Errorf("Remove: err=%v exists=%v", err, exists(a))
+	}
+	if err := tracker.Remove(a); err != nil {
+		t.Errorf("second Remove error: %v", err)
+	}
+
+	if err := tracker.RemoveOlderThan(time.Hour); err != nil || !exists(b) {
+		t.Errorf("RemoveOlderThan removed a recent file: err=%v", err)
+	}
+	if err := tracker.RemoveOlderThan(0); err != nil || exists(b) {
+		t.Errorf("RemoveOlderThan(0): err=%v exists=%v", err, exists(b))
+	}
+
+	c := newFile("c")
+	if err := tracker.RemoveAll(); err != nil || exists(c) {
+		t.Errorf("RemoveAll: err=%v exists=%v", err, exists(c))
+	}
+	if got := tracker.Pending(); len(got) != 0 {
+		t.Errorf("Pending() after RemoveAll = %v", got)
+	}
+}
@@ -1,7 +1,10 @@
 package kgp
 
 import (
+	"compress/zlib"
 	"errors"
+	"fmt"
+	"io"
 	"strings"
 )
 
@@ -13,7 +16,11 @@ type TransmitBuilder struct {
 	cmd         *Command
 	display     bool
 	imageData   []byte
+	reader      io.Reader
 	compression Compression
+	// tempUncompressed records that TransmitTempData or TransmitTempReader
+	// wrote the data uncompressed, so a later Compress is invalid.
+	tempUncompressed bool
 }
 
 // NewTransmit creates a new transmit action builder (transmit only, no display).
@@ -57,7 +64,8 @@ func (tb *TransmitBuilder) Dimensions(width, height int) *TransmitBuilder {
 	return tb
 }
 
-// Compression enables ZLIB compression.
+// Compression enables ZLIB compression. It must be called before
+// TransmitTempData or TransmitTempReader; BuildChecked reports a later call.
 func (tb *TransmitBuilder) Compress() *TransmitBuilder {
 	tb.cmd.SetKey("o", string(CompressionZlib))
 	tb.compression = CompressionZlib
@@ -66,24 +74,31 @@ func (tb *TransmitBuilder) Compress() *TransmitBuilder {
 
 // TransmitDirect embeds image data directly in the command (default).
 func (tb *TransmitBuilder) TransmitDirect(data []byte) *TransmitBuilder {
-	tb.cmd.SetKey("t", string(TransmitDirect))
-	tb.imageData = data
+	tb.setSource(TransmitDirect, data)
+	return tb
+}
+
+// TransmitReader streams image data from r directly in the command.
+// The data is read incrementally by WriteTo; if Compress is set it is
+// zlib-compressed on the fly. Commands using a reader must be written with
+// WriteTo rather than Build.
+func (tb *TransmitBuilder) TransmitReader(r io.Reader) *TransmitBuilder {
+	tb.setSource(TransmitDirect, nil)
+	tb.reader = r
 	return tb
 }
 
 // TransmitFile reads image data from a file path.
 func (tb *TransmitBuilder) TransmitFile(path string) *TransmitBuilder {
-	tb.cmd.SetKey("t", string(TransmitFile))
-	tb.imageData = []byte(path)
+	tb.setSource(TransmitFile, []byte(path))
 	return tb
 }
 
 // TransmitFileWithOffset reads image data from a file with offset and size.
 func (tb *TransmitBuilder) TransmitFileWithOffset(path string, offset, size int) *TransmitBuilder {
-	tb.cmd.SetKey("t", string(TransmitFile))
+	tb.setSource(TransmitFile, []byte(path))
 	tb.cmd.SetKeyInt("O", offset)
 	tb.cmd.SetKeyInt("S", size)
-	tb.imageData = []byte(path)
 	return tb
 }
 
@@ -109,19 +124,26 @@ func (tb *TransmitBuilder) TryTransmitTemp(path string) (*TransmitBuilder, error
 	if err := ValidateTempPath(path); err != nil {
 		return nil, err
 	}
-	tb.cmd.SetKey("t", string(TransmitTemp))
-	tb.imageData = []byte(path)
+	tb.setSource(TransmitTemp, []byte(path))
 	return tb, nil
 }
 
 // TransmitSharedMemory reads image data from POSIX shared memory.
 func (tb *TransmitBuilder) TransmitSharedMemory(name string, size int) *TransmitBuilder {
-	tb.cmd.SetKey("t", string(TransmitSharedMem))
+	tb.setSource(TransmitSharedMem, []byte(name))
 	tb.cmd.SetKeyInt("S", size)
-	tb.imageData = []byte(name)
 	return tb
 }
 
+// setSource sets the transmission medium and its payload, replacing any
+// data source set before, including a reader.
+func (tb *TransmitBuilder) setSource(medium TransmitMedium, data []byte) {
+	tb.cmd.SetKey("t", string(medium))
+	tb.imageData = data
+	tb.reader = nil
+	tb.tempUncompressed = false
+}
+
 // PlacementID sets the placement ID for the initial placement.
 func (tb *TransmitBuilder) PlacementID(id uint32) *TransmitBuilder {
 	tb.cmd.SetKeyUint32("p", id)
@@ -163,6 +185,13 @@ func (tb *TransmitBuilder) SourceRect(x, y, width, height int) *TransmitBuilder
 	return tb
 }
 
+// Layout sets the display size, cell offset and source rectangle computed by Fit.
+// The cursor must be moved to the layout's Column and Row within the box first.
+func (tb *TransmitBuilder) Layout(l Layout) *TransmitBuilder {
+	l.apply(tb.cmd)
+	return tb
+}
+
 // ZIndex sets the z-index (negative = below text, positive = above text).
 func (tb *TransmitBuilder) ZIndex(z int) *TransmitBuilder {
 	tb.cmd.SetKeyInt("z", z)
@@ -188,10 +217,63 @@ func (tb *TransmitBuilder) RelativeTo(parentImageID, parentPlacementID uint32, o
 	return tb
 }
 
-// Build constructs the final command.
+// WriteTo writes the command to w as a chunked transmission without
+// buffering the whole payload. Data set with TransmitReader is read
+// incrementally and, when Compress is set, compressed on the fly; any other
+// payload is written as-is. It implements io.WriterTo.
+func (tb *TransmitBuilder) WriteTo(w io.Writer) (int64, error) {
+	cw := NewChunkWriter(w, tb.cmd)
+
+	if tb.reader == nil {
+		if _, err := cw.Write(tb.imageData); err != nil {
+			return cw.Written(), err
+		}
+		err := cw.Close()
+		return cw.Written(), err
+	}
+
+	var dst io.Writer = cw
+	var zw *zlib.Writer
+	if tb.compression == CompressionZlib {
+		zw = zlib.NewWriter(cw)
+		dst = zw
+	}
+
+	if _, err := io.Copy(dst, tb.reader); err != nil {
+		return cw.Written(), err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return cw.Written(), err
+		}
+	}
+	err := cw.Close()
+	return cw.Written(), err
+}
+
+// Build constructs the final command. Data set with TransmitReader is not
+// included: the reader is ignored, so such commands must be written with
+// WriteTo.
 func (tb *TransmitBuilder) Build() *Command {
 	if len(tb.imageData) > 0 {
 		tb.cmd.SetPayload(tb.imageData)
 	}
 	return tb.cmd
 }
+
+// BuildChecked constructs the final command and validates it against the
+// protocol specification. It fails for data set with TransmitReader, which
+// Build would silently drop.
+func (tb *TransmitBuilder) BuildChecked() (*Command, error) {
+	if tb.reader != nil {
+		return nil, fmt.Errorf("%w: data set with TransmitReader must be written with WriteTo", ErrInvalidCommand)
+	}
+	if tb.tempUncompressed && tb.compression == CompressionZlib {
+		return nil, fmt.Errorf("%w: Compress called after the temporary file was written uncompressed", ErrInvalidCommand)
+	}
+	cmd := tb.Build()
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
@@ -1,7 +1,11 @@
 package kgp
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
 	"errors"
+	"io"
 	"strings"
 	"testing"
 )
@@ -363,3 +367,100 @@ func TestTransmitBuilder_CompleteFlow(t *testing.T) {
 		t.Error("Should contain z-index")
 	}
 }
+
+// TestTransmitBuilder_ReplaceReader tests that another data source replaces a reader
+func TestTransmitBuilder_ReplaceReader(t *testing.T) {
+	tb := NewTransmit().
+		Format(FormatPNG).
+		TransmitReader(strings.NewReader("reader data")).
+		TransmitFile("/tmp/x.png")
+
+	var buf bytes.Buffer
+	if _, err := tb.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	want := "\x1b_Ga=t,f=100,t=f;" + base64.StdEncoding.EncodeToString([]byte("/tmp/x.png")) + "\x1b\\"
+	if buf.String() != want {
+		t.Errorf("WriteTo = %q, want %q", buf.String(), want)
+	}
+	if _, err := tb.BuildChecked(); err != nil {
+		t.Errorf("BuildChecked error: %v", err)
+	}
+}
+
+// TestTransmitBuilder_TransmitReader tests streaming transmission from an io.Reader
+func TestTransmitBuilder_TransmitReader(t *testing.T) {
+	data := SolidColorImage(64, 64, 255, 0, 0, 255)
+	tb := NewTransmit().
+		ImageID(9).
+		Format(FormatRGBA).
+		Dimensions(64, 64).
+		TransmitReader(bytes.NewReader(data))
+
+	var buf bytes.Buffer
+	n, err := tb.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, want %d", n, buf.Len())
+	}
+
+	seqs := splitSequences(buf.String())
+	if len(seqs) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(seqs))
+	}
+	if !strings.Contains(seqs[0], "t=d") || !strings.Contains(seqs[0], "m=1") {
+		t.Errorf("unexpected first chunk: %q", seqs[0][:40])
+	}
+	if !strings.HasPrefix(seqs[len(seqs)-1], "\x1b_Gi=9,m=0;") {
+		t.Errorf("last chunk should start with i=9,m=0")
+	}
+	if !bytes.Equal(decodeChunkedPayload(t, buf.String()), data) {
+		t.Error("streamed payload does not match source data")
+	}
+}
+
+// TestTransmitBuilder_TransmitReaderCompress tests on-the-fly compression of streamed data
+func TestTransmitBuilder_TransmitReaderCompress(t *testing.T) {
+	data := SolidColorImage(128, 128, 0, 255, 0, 255)
+
+	var buf bytes.Buffer
+	_, err := NewTransmit().
+		Format(FormatRGBA).
+		Dimensions(128, 128).
+		Compress().
+		TransmitReader(bytes.NewReader(data)).
+		WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "o=z") {
+		t.Error("compressed stream should contain o=z")
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(decodeChunkedPayload(t, buf.String())))
+	if err != nil {
+		t.Fatalf("zlib reader error: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("decompressed payload does not match source data")
+	}
+}
+
+// TestTransmitBuilder_WriteToBytes tests WriteTo with an in-memory payload
+func TestTransmitBuilder_WriteToBytes(t *testing.T) {
+	tb := NewTransmit().Format(FormatPNG).TransmitDirect([]byte("test"))
+
+	var buf bytes.Buffer
+	if _, err := tb.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	if buf.String() != tb.Build().Encode() {
+		t.Errorf("got %q, want %q", buf.String(), tb.Build().Encode())
+	}
+}
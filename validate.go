@@ -0,0 +1,305 @@
+package kgp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrInvalidCommand indicates a command that violates the protocol specification.
+// Errors returned by Validate and BuildChecked wrap it.
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Validate checks the command against the protocol specification: required
+// keys for the action, the delete mode and the transmission medium, value
+// ranges, incompatible key combinations, and, for uncompressed RGB/RGBA data
+// sent directly, that the payload length matches width*height*bpp.
+// All problems found are reported, joined into a single error.
+func (c *Command) Validate() error {
+	v := &validator{c: c}
+
+	v.uintKeys("i", "I", "p", "q")
+	v.oneOf("q", "0", "1", "2")
+	if v.nonZero("i") && v.nonZero("I") {
+		v.fail("image ID (i) and image number (I) are mutually exclusive")
+	}
+
+	switch action := c.Action(); action {
+	case ActionTransmit, ActionQuery:
+		v.transmission()
+	case ActionTransmitDisplay:
+		v.transmission()
+		v.display()
+	case ActionPut:
+		v.requireImage(action)
+		v.display()
+	case ActionDelete:
+		v.deletion()
+	case ActionFrame:
+		v.requireImage(action)
+		v.frame()
+	case ActionAnimate:
+		v.requireImage(action)
+		v.uintKeys("s", "v", "c")
+		v.intKeys("z")
+		v.oneOf("s", "1", "2", "3")
+	case ActionCompose:
+		v.requireImage(action)
+		v.uintKeys("x", "y", "w", "h", "X", "Y")
+		v.required("r", "c")
+		v.positive("r", "c")
+		v.oneOf("C", "0", "1")
+	default:
+		v.fail(fmt.Sprintf("unknown action %q", action))
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// validator accumulates the problems found in a command.
+type validator struct {
+	c    *Command
+	errs []error
+}
+
+func (v *validator) fail(msg string) {
+	v.errs = append(v.errs, fmt.Errorf("%w: %s", ErrInvalidCommand, msg))
+}
+
+// intKey returns the integer value of a key, or ok=false if it is absent or
+// not an integer. Non-integer values are reported.
+func (v *validator) intKey(key string) (int64, bool) {
+	s, ok := v.c.controlData[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.fail(fmt.Sprintf("%s=%q is not an integer", key, s))
+		return 0, false
+	}
+	return n, true
+}
+
+func (v *validator) has(key string) bool {
+	_, ok := v.c.controlData[key]
+	return ok
+}
+
+func (v *validator) nonZero(key string) bool {
+	s, ok := v.c.controlData[key]
+	return ok && s != "0"
+}
+
+// uintKeys checks that the keys, when present, are non-negative 32-bit integers.
+func (v *validator) uintKeys(keys ...string) {
+	for _, k := range keys {
+		if n, ok := v.intKey(k); ok && (n < 0 || n > 1<<32-1) {
+			v.fail(fmt.Sprintf("%s=%d is out of range", k, n))
+		}
+	}
+}
+
+// intKeys checks that the keys, when present, are signed 32-bit integers.
+func (v *validator) intKeys(keys ...string) {
+	for _, k := range keys {
+		if n, ok := v.intKey(k); ok && (n < -1<<31 || n > 1<<31-1) {
+			v.fail(fmt.Sprintf("%s=%d is out of range", k, n))
+		}
+	}
+}
+
+func (v *validator) positive(keys ...string) {
+	for _, k := range keys {
+		if n, ok := v.intKey(k); ok && n <= 0 {
+			v.fail(fmt.Sprintf("%s must be positive", k))
+		}
+	}
+}
+
+func (v *validator) required(keys ...string) {
+	for _, k := range keys {
+		if !v.has(k) {
+			v.fail(fmt.Sprintf("missing required key %s", k))
+		}
+	}
+}
+
+func (v *validator) oneOf(key string, values ...string) {
+	s, ok := v.c.controlData[key]
+	if !ok {
+		return
+	}
+	for _, val := range values {
+		if s == val {
+			return
+		}
+	}
+	v.fail(fmt.Sprintf("%s=%q is not one of %v", key, s, values))
+}
+
+func (v *validator) requireImage(action Action) {
+	if !v.nonZero("i") && !v.nonZero("I") {
+		v.fail(fmt.Sprintf("action %q requires a non-zero image ID or image number", action))
+	}
+}
+
+// bytesPerPixel returns the pixel size for raw formats, or 0 for PNG.
+func (v *validator) bytesPerPixel() int64 {
+	switch v.c.controlData["f"] {
+	case "24":
+		return 3
+	case "", "32":
+		return 4
+	}
+	return 0
+}
+
+// checkPixelData checks dimensions and, for uncompressed raw data sent
+// directly, the payload length.
+func (v *validator) checkPixelData() {
+	v.oneOf("f", "24", "32", "100")
+	v.oneOf("o", string(CompressionZlib))
+	v.uintKeys("s", "v")
+
+	bpp := v.bytesPerPixel()
+	if bpp == 0 {
+		return
+	}
+	v.required("s", "v")
+	width, okW := v.intKey("s")
+	height, okH := v.intKey("v")
+	if !okW || !okH {
+		return
+	}
+	if width == 0 || height == 0 {
+		v.fail("dimensions must be non-zero for RGB/RGBA data")
+		return
+	}
+
+	medium := v.c.controlData["t"]
+	if v.has("o") || (medium != "" && medium != string(TransmitDirect)) {
+		return
+	}
+	if want := width * height * bpp; int64(len(v.c.payload)) != want {
+		v.fail(fmt.Sprintf("payload is %d bytes, want %d for %dx%d at %d bytes per pixel",
+			len(v.c.payload), want, width, height, bpp))
+	}
+}
+
+func (v *validator) transmission() {
+	v.oneOf("t", string(TransmitDirect), string(TransmitFile), string(TransmitTemp), string(TransmitSharedMem))
+	v.uintKeys("S", "O")
+	v.checkPixelData()
+
+	switch TransmitMedium(v.c.controlData["t"]) {
+	case TransmitFile, TransmitSharedMem:
+		if len(v.c.payload) == 0 {
+			v.fail("file and shared memory transmission require a path or name payload")
+		}
+	case TransmitTemp:
+		if err := ValidateTempPath(string(v.c.payload)); err != nil {
+			v.fail(err.Error())
+		}
+	default:
+		if len(v.c.payload) == 0 && v.c.Action() != ActionQuery {
+			v.fail("direct transmission requires payload data")
+		}
+		if v.has("O") {
+			v.fail("offset (O) is only valid for file transmission")
+		}
+	}
+}
+
+func (v *validator) display() {
+	v.uintKeys("x", "y", "w", "h", "X", "Y", "c", "r", "Q")
+	v.intKeys("z", "H", "V")
+	v.oneOf("C", "0", "1")
+	v.oneOf("U", "0", "1")
+
+	if v.nonZero("U") {
+		if v.has("X") || v.has("Y") {
+			v.fail("virtual placement (U=1) cannot use a cell offset (X, Y)")
+		}
+		if v.has("P") {
+			v.fail("virtual placement (U=1) cannot be relative to a parent (P)")
+		}
+	}
+
+	if v.has("P") || v.has("Q") {
+		v.required("P", "Q")
+		v.positive("P")
+		if v.c.controlData["P"] == v.c.controlData["i"] && v.c.controlData["Q"] == v.c.controlData["p"] {
+			v.fail("placement cannot be relative to itself")
+		}
+	} else if v.has("H") || v.has("V") {
+		v.fail("relative offsets (H, V) require a parent placement (P, Q)")
+	}
+}
+
+func (v *validator) frame() {
+	v.uintKeys("x", "y", "c", "r", "Y")
+	v.intKeys("z")
+	v.oneOf("X", "0", "1")
+	v.positive("r")
+	v.oneOf("t", string(TransmitDirect), string(TransmitFile), string(TransmitTemp), string(TransmitSharedMem))
+	if len(v.c.payload) > 0 || v.has("s") || v.has("v") {
+		v.checkPixelData()
+	}
+}
+
+func (v *validator) deletion() {
+	mode, ok := v.c.controlData["d"]
+	if !ok {
+		// The protocol defaults to deleting all placements
+		return
+	}
+
+	switch DeleteMode(mode) {
+	case DeleteByImageID, DeleteByImageIDFree, DeleteByImageNumber, DeleteByImageNumberFree:
+	default:
+		// Only deletes by image ID or number target a single placement
+		if v.has("p") {
+			v.fail(fmt.Sprintf("delete mode %q does not take a placement ID", mode))
+		}
+	}
+
+	switch DeleteMode(mode) {
+	case DeleteAllPlacements, DeleteAllPlacementsFree, DeleteByCursor, DeleteByCursorFree:
+	case DeleteByImageID, DeleteByImageIDFree, DeleteFrames, DeleteFramesFree:
+		if !v.nonZero("i") {
+			v.fail(fmt.Sprintf("delete mode %q requires a non-zero image ID", mode))
+		}
+	case DeleteByImageNumber, DeleteByImageNumberFree:
+		if !v.nonZero("I") {
+			v.fail(fmt.Sprintf("delete mode %q requires a non-zero image number", mode))
+		}
+	case DeleteByPlacementID, DeleteByPlacementIDFree:
+		// d=p deletes placements intersecting the cell x,y
+		v.required("x", "y")
+		v.positive("x", "y")
+	case DeleteByCell, DeleteByCellFree:
+		// d=q deletes placements intersecting the cell x,y with z-index z
+		v.required("x", "y", "z")
+		v.positive("x", "y")
+		v.intKeys("z")
+	case DeleteByColumn, DeleteByColumnFree:
+		v.required("x")
+		v.positive("x")
+	case DeleteByRow, DeleteByRowFree:
+		v.required("y")
+		v.positive("y")
+	case DeleteByZIndex, DeleteByZIndexFree:
+		v.required("z")
+		v.intKeys("z")
+	case DeleteByIDRange, DeleteByIDRangeFree:
+		v.required("x", "y")
+		start, okStart := v.intKey("x")
+		end, okEnd := v.intKey("y")
+		if okStart && okEnd && (start <= 0 || end < start) {
+			v.fail(fmt.Sprintf("invalid image ID range %d-%d", start, end))
+		}
+	default:
+		v.fail(fmt.Sprintf("unknown delete mode %q", mode))
+	}
+}
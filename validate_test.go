@@ -0,0 +1,156 @@
+package kgp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidateValid tests that well-formed builder output validates
+func TestValidateValid(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  *Command
+	}{
+		{"Transmit PNG", NewTransmit().ImageID(1).Format(FormatPNG).TransmitDirect([]byte("png")).Build()},
+		{"Transmit RGBA", NewTransmitDisplay().Format(FormatRGBA).Dimensions(2, 2).TransmitDirect(SolidColorImage(2, 2, 0, 0, 0, 255)).Build()},
+		{"Transmit RGB", NewTransmit().Format(FormatRGB).Dimensions(1, 1).TransmitDirect([]byte{1, 2, 3}).Build()},
+		{"Transmit compressed", NewTransmit().Format(FormatRGBA).Dimensions(100, 100).Compress().TransmitDirect([]byte("zlib")).Build()},
+		{"Transmit file", NewTransmit().Format(FormatPNG).TransmitFileWithOffset("/tmp/a.png", 10, 20).Build()},
+		{"Transmit temp", NewTransmit().Format(FormatPNG).TransmitTemp("/tmp/tty-graphics-protocol-1.png").Build()},
+		{"Transmit shm", NewTransmit().Format(FormatRGBA).Dimensions(10, 10).TransmitSharedMemory("/shm", 400).Build()},
+		{"Put", NewPut(10).PlacementID(1).DisplaySize(20, 10).CellOffset(2, 3).ZIndex(-5).Build()},
+		{"Put virtual", NewPut(10).VirtualPlacement().DisplaySize(4, 2).Build()},
+		{"Put relative", NewPut(10).PlacementID(2).RelativeTo(11, 1, -3, 2).Build()},
+		{"Delete all", DeleteAll()},
+		{"Delete image", DeleteImageFree(3)},
+		{"Delete placement", NewDelete(DeleteByImageID).ImageID(3).PlacementID(1).Build()},
+		{"Delete placement by number", NewDelete(DeleteByImageNumberFree).ImageNumber(3).PlacementID(1).Build()},
+		{"Delete intersecting cell", NewDelete(DeleteByPlacementID).Cell(3, 4).Build()},
+		{"Delete cell and z-index", NewDelete(DeleteByCell).Cell(3, 4).ZIndex(-1).Build()},
+		{"Delete z-index", NewDelete(DeleteByZIndex).ZIndex(-1).Build()},
+		{"Delete range", NewDelete(DeleteByIDRangeFree).IDRange(1, 10).Build()},
+		{"Frame", NewFrame(5).Format(FormatRGB).Dimensions(1, 1).FrameData([]byte{1, 2, 3}).Gap(40).Build()},
+		{"Frame without data", NewFrame(5).BackgroundFrame(1).Gap(40).Build()},
+		{"Animate", PlayAnimationLoop(5)},
+		{"Animate gapless frame", NewAnimate(5).Frame(2).GapOverride(0).Build().SetKeyInt("z", -1)},
+		{"Compose", NewCompose(5).SourceFrame(1).DestFrame(2).Build()},
+		{"Query", QuerySupport()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cmd.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
+
+// TestValidateInvalid tests that invalid commands are rejected with a descriptive error
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     *Command
+		wantMsg string
+	}{
+		{"Put without image", NewPut(0).Build(), "requires a non-zero image ID"},
+		{"RGBA without dimensions", NewTransmit().Format(FormatRGBA).TransmitDirect([]byte{1, 2, 3, 4}).Build(), "missing required key s"},
+		{"RGBA zero dimensions", NewTransmit().Format(FormatRGBA).Dimensions(0, 1).TransmitDirect([]byte{1}).Build(), "dimensions must be non-zero"},
+		{"RGBA payload length", NewTransmit().Format(FormatRGBA).Dimensions(2, 2).TransmitDirect([]byte{1, 2, 3}).Build(), "payload is 3 bytes, want 16"},
+		{"Direct without payload", NewTransmit().Format(FormatPNG).Build(), "requires payload data"},
+		{"Invalid format", NewTransmit().Format(Format(7)).TransmitDirect([]byte("x")).Build(), `f="7"`},
+		{"Invalid temp path", NewTransmit().Format(FormatPNG).Build().SetKey("t", "t").SetPayload([]byte("/tmp/x")), "tty-graphics-protocol"},
+		{"Offset with direct", NewTransmit().Format(FormatPNG).TransmitDirect([]byte("x")).Build().SetKeyInt("O", 1), "offset (O)"},
+		{"Virtual with offset", NewPut(1).VirtualPlacement().CellOffset(1, 1).Build(), "cannot use a cell offset"},
+		{"Virtual relative", NewPut(1).VirtualPlacement().RelativeTo(2, 1, 0, 0).Build(), "cannot be relative"},
+		{"Relative to self", NewPut(1).PlacementID(1).RelativeTo(1, 1, 0, 0).Build(), "relative to itself"},
+		{"Offsets without parent", NewPut(1).Build().SetKeyInt("H", 1), "require a parent placement"},
+		{"Negative display size", NewPut(1).DisplaySize(-1, 2).Build(), "c=-1 is out of range"},
+		{"Invalid cursor movement", NewPut(1).Build().SetKey("C", "2"), `C="2"`},
+		{"Non-integer value", NewPut(1).Build().SetKey("c", "wide"), "not an integer"},
+		{"Both ID and number", NewPut(1).ImageNumber(2).Build(), "mutually exclusive"},
+		{"Invalid suppression", NewPut(1).ResponseSuppression(ResponseSuppression(3)).Build(), `q="3"`},
+		{"Delete by cell without cell", NewDelete(DeleteByCell).Build(), "missing required key x"},
+		{"Delete by cell without z-index", NewDelete(DeleteByCell).Cell(1, 1).Build(), "missing required key z"},
+		{"Delete by image without ID", NewDelete(DeleteByImageID).Build(), "requires a non-zero image ID"},
+		{"Delete by number without number", NewDelete(DeleteByImageNumber).Build(), "requires a non-zero image number"},
+		{"Delete intersecting without cell", NewDelete(DeleteByPlacementID).Build(), "missing required key x"},
+		{"Delete intersecting with placement", NewDelete(DeleteByPlacementID).Cell(1, 1).PlacementID(2).Build(), "does not take a placement ID"},
+		{"Delete inverted range", NewDelete(DeleteByIDRange).IDRange(10, 1).Build(), "invalid image ID range"},
+		{"Delete unknown mode", NewDelete(DeleteMode("k")).Build(), "unknown delete mode"},
+		{"Frame without image", NewFrame(0).Build(), "requires a non-zero image ID"},
+		{"Frame raw without dimensions", NewFrame(1).FrameData([]byte{1, 2, 3, 4}).Build(), "missing required key s"},
+		{"Frame invalid composition", NewFrame(1).Composition(CompositionMode(2)).Build(), `X="2"`},
+		{"Animate invalid state", NewAnimate(1).State(AnimationState(9)).Build(), `s="9"`},
+		{"Compose without frames", NewCompose(1).Build(), "missing required key r"},
+		{"Unknown action", NewCommand(Action("x")), "unknown action"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cmd.Validate()
+			if !errors.Is(err, ErrInvalidCommand) {
+				t.Fatalf("Validate() = %v, want ErrInvalidCommand", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("Validate() = %q, want message containing %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+// TestValidateReportsAllProblems tests that every problem is reported
+func TestValidateReportsAllProblems(t *testing.T) {
+	err := NewPut(0).DisplaySize(-1, -1).Build().Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n := strings.Count(err.Error(), "invalid command"); n != 3 {
+		t.Errorf("expected 3 problems, got %d: %v", n, err)
+	}
+}
+
+// TestBuildChecked tests BuildChecked on every builder
+func TestBuildChecked(t *testing.T) {
+	builds := []struct {
+		name  string
+		valid func() (*Command, error)
+		bad   func() (*Command, error)
+	}{
+		{
+			"Transmit",
+			NewTransmit().Format(FormatPNG).TransmitDirect([]byte("x")).BuildChecked,
+			NewTransmit().Format(FormatRGBA).TransmitDirect([]byte("x")).BuildChecked,
+		},
+		{"Put", NewPut(1).BuildChecked, NewPut(0).BuildChecked},
+		{"Delete", NewDelete(DeleteByRow).Row(2).BuildChecked, NewDelete(DeleteByRow).BuildChecked},
+		{"Frame", NewFrame(1).Gap(10).BuildChecked, NewFrame(0).BuildChecked},
+		{"Animate", NewAnimate(1).State(AnimationStop).BuildChecked, NewAnimate(0).BuildChecked},
+		{"Compose", NewCompose(1).SourceFrame(1).DestFrame(2).BuildChecked, NewCompose(1).BuildChecked},
+		{"Query", NewQuery().Format(FormatRGB).Dimensions(1, 1).TestData([]byte{0, 0, 0}).BuildChecked, NewQuery().Format(FormatRGB).BuildChecked},
+	}
+
+	for _, tt := range builds {
+		t.Run(tt.name, func(t *testing.T) {
+			if cmd, err := tt.valid(); err != nil || cmd == nil {
+				t.Errorf("valid BuildChecked() = %v, %v", cmd, err)
+			}
+			if cmd, err := tt.bad(); !errors.Is(err, ErrInvalidCommand) || cmd != nil {
+				t.Errorf("invalid BuildChecked() = %v, %v", cmd, err)
+			}
+		})
+	}
+}
+
+// TestBuildCheckedTransmitReader tests that streamed payloads cannot be built
+func TestBuildCheckedTransmitReader(t *testing.T) {
+	cmd, err := NewTransmit().
+		Format(FormatRGBA).
+		Dimensions(100, 100).
+		TransmitReader(strings.NewReader("data")).
+		BuildChecked()
+	if !errors.Is(err, ErrInvalidCommand) || cmd != nil {
+		t.Errorf("BuildChecked() = %v, %v; want ErrInvalidCommand", cmd, err)
+	}
+}